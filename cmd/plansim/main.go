@@ -0,0 +1,235 @@
+// plansim 离线规划模拟：读取区服快照 JSON，不连接 hub 与 MySQL，
+// 运行副本分配与装备规划并输出表格或 JSON。用于预演属性变化、为问题报告附带可复现的输入。
+//
+//	go run ./cmd/plansim -in snapshot.json
+//	go run ./cmd/plansim -in snapshot.json -set 小小鸟.等级=60 -set 大大鸟.等级=60 -json
+//
+// 快照格式：
+//
+//	{
+//	  "zone": "中州1区",
+//	  "merge_state": "未合区",            // 可选，覆盖全部角色的 合区
+//	  "roles": [{"角色名": "小小鸟", "职业": "道士", "等级": 55, ...}],
+//	  "previous": [{"role": "小小鸟", "target": {"map": "五蛇殿", "floor": 1}}]
+//	}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"wgserver/internal/services/alloc"
+	eq "wgserver/internal/services/equipment"
+	"wgserver/internal/services/roles"
+	t "wgserver/internal/types"
+)
+
+type snapshot struct {
+	Zone       string             `json:"zone"`
+	MergeState string             `json:"merge_state"`
+	Roles      []t.RoleAttributes `json:"roles"`
+	Previous   []alloc.Assignment `json:"previous"`
+}
+
+type output struct {
+	Zone    string               `json:"zone"`
+	Plan    alloc.PlanResult     `json:"plan"`
+	Outfits map[string]eq.Outfit `json:"outfits"`
+}
+
+// overrides 可重复的 -set 角色名.字段=值
+type overrides []string
+
+func (o *overrides) String() string     { return strings.Join(*o, ",") }
+func (o *overrides) Set(v string) error { *o = append(*o, v); return nil }
+
+func main() {
+	in := flag.String("in", "-", "zone snapshot JSON file (- for stdin)")
+	rulesFile := flag.String("rules", "", "map rules JSON (default: built-in rules)")
+	asJSON := flag.Bool("json", false, "print JSON instead of tables")
+	var sets overrides
+	flag.Var(&sets, "set", "override a role attribute before planning: 角色名.字段=值 (字段: 等级/技能/幸运/道术/血量/合区/职业)")
+	flag.Parse()
+
+	if *rulesFile != "" {
+		if _, err := alloc.LoadRules(*rulesFile); err != nil {
+			log.Fatalf("load rules: %v", err)
+		}
+	}
+	snap, err := readSnapshot(*in)
+	if err != nil {
+		log.Fatalf("read snapshot: %v", err)
+	}
+	for _, s := range sets {
+		if err := applyOverride(snap, s); err != nil {
+			log.Fatalf("-set %s: %v", s, err)
+		}
+	}
+	zs := buildZone(snap)
+
+	out := output{
+		Zone:    snap.Zone,
+		Plan:    alloc.PlanSnapshot(snap.Zone, zs, snap.Previous),
+		Outfits: eq.PlanSnapshot(zs),
+	}
+	sort.Slice(out.Plan.Assignments, func(i, j int) bool {
+		return out.Plan.Assignments[i].RoleName < out.Plan.Assignments[j].RoleName
+	})
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printTables(zs, out)
+}
+
+func readSnapshot(path string) (*snapshot, error) {
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, err
+	}
+	if len(snap.Roles) == 0 {
+		return nil, fmt.Errorf("no roles in snapshot")
+	}
+	if snap.Zone == "" {
+		snap.Zone = snap.Roles[0].Zone
+	}
+	return &snap, nil
+}
+
+func applyOverride(snap *snapshot, s string) error {
+	lhs, val, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("want 角色名.字段=值")
+	}
+	name, field, ok := strings.Cut(lhs, ".")
+	if !ok {
+		return fmt.Errorf("want 角色名.字段=值")
+	}
+	for i := range snap.Roles {
+		r := &snap.Roles[i]
+		if r.RoleName != name {
+			continue
+		}
+		switch field {
+		case "合区":
+			r.MergeState = val
+			return nil
+		case "职业":
+			r.Class = val
+			return nil
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		switch field {
+		case "等级":
+			r.Level = n
+		case "技能":
+			r.Skill = n
+		case "幸运":
+			r.Lucky = n
+		case "道术":
+			r.Magic = n
+		case "血量":
+			r.HP = n
+		default:
+			return fmt.Errorf("unknown field %q", field)
+		}
+		return nil
+	}
+	return fmt.Errorf("role %q not in snapshot", name)
+}
+
+func buildZone(snap *snapshot) *roles.ZoneState {
+	zs := &roles.ZoneState{Roles: map[string]*roles.RoleInfo{}, ClientByRole: map[string]string{}}
+	for _, r := range snap.Roles {
+		r.Zone = snap.Zone
+		if snap.MergeState != "" {
+			r.MergeState = snap.MergeState
+		}
+		zs.Roles[r.RoleName] = &roles.RoleInfo{RoleAttributes: r}
+		zs.ClientByRole[r.RoleName] = r.ClientID
+	}
+	return zs
+}
+
+var slotOrder = []string{"头", "项链", "腰带", "鞋子", "手镯1", "手镯2", "戒指1", "戒指2"}
+
+func printTables(zs *roles.ZoneState, out output) {
+	as := out.Plan.Assignments
+	fmt.Printf("zone=%s mode=%s assignments=%d/%d moves=%d kept=%d objective=%d\n\n",
+		out.Zone, out.Plan.Mode, len(as), len(zs.Roles), out.Plan.Moves, out.Plan.Kept, out.Plan.Objective)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "角色\t职业\t等级\t幸运\t道术\t技能\t地图\t层\t分支\t排名\t强度")
+	assigned := map[string]bool{}
+	for _, a := range as {
+		assigned[a.RoleName] = true
+		r := zs.Roles[a.RoleName]
+		branch, rank, score := "", 0, 0
+		if a.Reason != nil {
+			branch, rank, score = a.Reason.Branch, a.Reason.Rank, a.Reason.Score
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%d\t%s\t%d\t%d\n",
+			a.RoleName, r.Class, r.Level, r.Lucky, r.Magic, r.Skill, a.Target.Map, a.Target.Floor, branch, rank, score)
+	}
+	var names []string
+	for name := range zs.Roles {
+		if !assigned[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := zs.Roles[name]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t(未分配)\t\t\t\t\n", name, r.Class, r.Level, r.Lucky, r.Magic, r.Skill)
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "角色\t"+strings.Join(slotOrder, "\t"))
+	names = names[:0]
+	for name := range out.Outfits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		row := []string{name}
+		for _, s := range slotOrder {
+			row = append(row, nonEmpty(out.Outfits[name].BySlot[s]))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func nonEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
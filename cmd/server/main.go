@@ -1,59 +1,116 @@
-package main
-
-import (
-	"context"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	"wgserver/internal/config"
-	"wgserver/internal/db"
-	"wgserver/internal/logger"
-	"wgserver/internal/server"
-)
-
-func main() {
-	cfg := config.Load()
-
-	// init loggers directory
-	if err := os.MkdirAll(cfg.LogDir, 0755); err != nil {
-		log.Fatalf("failed to create log dir: %v", err)
-	}
-
-	// init DB
-	if err := db.Init(cfg); err != nil {
-		log.Fatalf("failed to init db: %v", err)
-	}
-	defer db.Close()
-
-	// http server + websocket
-	mux := http.NewServeMux()
-	hs := server.NewHub(cfg)
-	mux.HandleFunc("/ws", hs.HandleWS)
-
-	httpServer := &http.Server{
-		Addr:              cfg.ListenAddr(),
-		Handler:           mux,
-		ReadHeaderTimeout: 10 * time.Second,
-	}
-
-	go func() {
-		logger.Connection().Printf("listening on %s", cfg.ListenAddr())
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("http server error: %v", err)
-		}
-	}()
-
-	// graceful shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	<-stop
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	_ = httpServer.Shutdown(ctx)
-	logger.Connection().Println("server shutdown")
-}
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"wgserver/internal/config"
+	"wgserver/internal/db"
+	"wgserver/internal/logger"
+	"wgserver/internal/server"
+	"wgserver/internal/services/alloc"
+	"wgserver/internal/services/compliance"
+	"wgserver/internal/services/roles"
+)
+
+func main() {
+	cfg := config.Load()
+
+	// init loggers directory
+	if err := os.MkdirAll(cfg.LogDir, 0755); err != nil {
+		log.Fatalf("failed to create log dir: %v", err)
+	}
+
+	// init DB
+	if err := db.Init(cfg); err != nil {
+		log.Fatalf("failed to init db: %v", err)
+	}
+	defer db.Close()
+
+	// same-name role conflicts between clients
+	roles.Instance().SetConflictPolicy(roles.ParseConflictPolicy(cfg.RoleConflictPolicy))
+	// roles that stop reporting go offline
+	roles.Instance().SetStaleAfter(cfg.RoleStaleAfter)
+	// zone aliases from previous merges
+	if err := roles.Instance().LoadZoneAliases(); err != nil {
+		log.Printf("load zone aliases: %v", err)
+	}
+	// role attribute validation rules
+	if cfg.RoleRulesFile != "" {
+		if err := roles.Instance().LoadValidationRules(cfg.RoleRulesFile); err != nil {
+			log.Fatalf("failed to load role rules: %v", err)
+		}
+	}
+	// map planning rules (hot reloaded)
+	if cfg.MapRulesFile != "" {
+		if _, err := alloc.LoadRules(cfg.MapRulesFile); err != nil {
+			log.Fatalf("failed to load map rules: %v", err)
+		}
+		go alloc.WatchRules(cfg.MapRulesFile, cfg.MapRulesReload, func(rl *alloc.Rules) {
+			server.ReplanAll(fmt.Sprintf("rules v%d", rl.Version))
+		})
+	}
+	// admin pins/exclusions for the planner
+	if err := alloc.LoadOverrides(); err != nil {
+		log.Printf("load plan overrides: %v", err)
+	}
+	// plan history: latest version per zone as the baseline for diffs
+	// versions are only persisted once the latest ones are loaded, otherwise they restart at 1 and collide
+	if err := alloc.LoadPlanHistory(); err != nil {
+		log.Printf("load plan history: %v; plan history will not be persisted", err)
+	} else {
+		alloc.StartHistoryWriter()
+	}
+	// assignment compliance tracking
+	compliance.Instance().Start()
+	// role write-behind persister
+	roles.Instance().StartPersister(cfg)
+
+	// http server + websocket
+	mux := http.NewServeMux()
+	hs := server.NewHub(cfg)
+	mux.HandleFunc("/ws", hs.HandleWS)
+	mux.HandleFunc("/admin/roles/persist", hs.HandleAdminPersist)
+	mux.HandleFunc("/admin/roles/quarantine", hs.HandleAdminQuarantine)
+	mux.HandleFunc("/admin/roles/quarantine/release", hs.HandleAdminQuarantineRelease)
+	mux.HandleFunc("/admin/zones", hs.HandleAdminZones)
+	mux.HandleFunc("/admin/zones/merge", hs.HandleAdminZoneMerge)
+	mux.HandleFunc("/admin/plans", hs.HandleAdminPlans)
+	mux.HandleFunc("/admin/plans/overrides", hs.HandleAdminOverrides)
+	mux.HandleFunc("/admin/plans/compliance", hs.HandleAdminCompliance)
+	mux.HandleFunc("/admin/plans/history", hs.HandleAdminPlanHistory)
+	mux.HandleFunc("/admin/plans/timeline", hs.HandleAdminRoleTimeline)
+	mux.HandleFunc("/admin/plans/fairness", hs.HandleAdminFairness)
+
+	httpServer := &http.Server{
+		Addr:              cfg.ListenAddr(),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logger.Connection().Printf("listening on %s", cfg.ListenAddr())
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("http server error: %v", err)
+		}
+	}()
+
+	// graceful shutdown
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = httpServer.Shutdown(ctx)
+	// flush pending role writes before closing the db
+	_ = roles.Instance().StopPersister(ctx)
+	_ = alloc.StopHistoryWriter(ctx)
+	logger.Connection().Println("server shutdown")
+}
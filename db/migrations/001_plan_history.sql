@@ -0,0 +1,43 @@
+-- Plan history: one plan_history row per plan version, one map_allocations row per role target.
+-- Databases created from an earlier db/schema.sql have a map_allocations table without plan_id
+-- (unique on zone+role_name, never written); it is renamed to map_allocations_legacy and rebuilt.
+-- Safe to run repeatedly. The server runs this file on startup (alloc.LoadPlanHistory).
+USE wgserver;
+
+CREATE TABLE IF NOT EXISTS plan_history (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  zone VARCHAR(128) NOT NULL,
+  version INT NOT NULL,
+  reason VARCHAR(255) NOT NULL DEFAULT '',
+  schedule VARCHAR(64) NOT NULL DEFAULT '',
+  moves INT NOT NULL DEFAULT 0,
+  changes TEXT,
+  created_at DATETIME NOT NULL,
+  UNIQUE KEY uk_zone_version (zone, version),
+  INDEX idx_zone_created (zone, created_at)
+) ENGINE=InnoDB;
+
+-- rename only the legacy layout: map_allocations exists and has no plan_id column
+SET @legacy_map_allocations = (SELECT COUNT(*) FROM information_schema.TABLES
+  WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME='map_allocations'
+  AND NOT EXISTS (SELECT 1 FROM information_schema.COLUMNS
+    WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME='map_allocations' AND COLUMN_NAME='plan_id'));
+SET @rename_map_allocations = IF(@legacy_map_allocations > 0,
+  'RENAME TABLE map_allocations TO map_allocations_legacy', 'DO 0');
+PREPARE rename_map_allocations FROM @rename_map_allocations;
+EXECUTE rename_map_allocations;
+DEALLOCATE PREPARE rename_map_allocations;
+
+CREATE TABLE IF NOT EXISTS map_allocations (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  plan_id BIGINT NOT NULL,
+  zone VARCHAR(128) NOT NULL,
+  role_name VARCHAR(128) NOT NULL,
+  map_name VARCHAR(128) NOT NULL,
+  floor INT DEFAULT 1,
+  branch VARCHAR(64) NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  UNIQUE KEY uk_plan_role (plan_id, role_name),
+  INDEX idx_zone_role_created (zone, role_name, created_at),
+  INDEX idx_created (created_at)
+) ENGINE=InnoDB;
@@ -0,0 +1,9 @@
+// Package migrations 内嵌数据库升级脚本：服务启动时执行的 DDL 与手动执行的脚本是同一份文件
+package migrations
+
+import _ "embed"
+
+// PlanHistory 规划历史表（plan_history / map_allocations），见 001_plan_history.sql
+//
+//go:embed 001_plan_history.sql
+var PlanHistory string
@@ -1,41 +1,120 @@
-package config
-
-import (
-	"fmt"
-	"os"
-)
-
-type Config struct {
-	Port   int
-	LogDir string
-	DBDSN  string
-	Env    string
-}
-
-func Load() *Config {
-	cfg := &Config{
-		Port:   8888,
-		LogDir: "logs",
-		DBDSN:  getenv("MYSQL_DSN", "root:1qaz2wsx@tcp(47.116.127.1:3306)/wgserver?parseTime=true&charset=utf8mb4,utf8"),
-		Env:    getenv("APP_ENV", "dev"),
-	}
-	if v := os.Getenv("PORT"); v != "" {
-		var p int
-		_, _ = fmt.Sscanf(v, "%d", &p)
-		if p > 0 {
-			cfg.Port = p
-		}
-	}
-	return cfg
-}
-
-func (c *Config) ListenAddr() string {
-	return fmt.Sprintf(":%d", c.Port)
-}
-
-func getenv(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
-	}
-	return def
-}
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+type Config struct {
+	Port   int
+	LogDir string
+	DBDSN  string
+	Env    string
+
+	// 角色写回（write-behind）参数
+	PersistBatchSize     int
+	PersistFlushInterval time.Duration
+	PersistMaxRetries    int
+
+	// 同名角色被多个客户端上报时的处理策略：keep_first / take_over / reject
+	RoleConflictPolicy string
+
+	// 角色属性校验规则文件（JSON），为空使用内置默认规则
+	RoleRulesFile string
+
+	// 角色超过该时长未上报即转为离线（0 表示不清理）
+	RoleStaleAfter time.Duration
+
+	// 副本规划规则文件（JSON）与热加载检查间隔；为空使用内置规则
+	MapRulesFile   string
+	MapRulesReload time.Duration
+
+	// 分配执行跟踪：到达超时、离开目标地图的宽限期、连续失败多少次后改派（0 不改派）及改派排除时长
+	ComplianceArriveTimeout time.Duration
+	ComplianceWanderGrace   time.Duration
+	ComplianceReassignAfter int
+	ComplianceReassignTTL   time.Duration
+
+	// 规划历史（plan_history / map_allocations）保留时长，0 表示一直保留
+	PlanHistoryRetention time.Duration
+}
+
+func Load() *Config {
+	cfg := &Config{
+		Port:   8888,
+		LogDir: "logs",
+		DBDSN:  getenv("MYSQL_DSN", "root:1qaz2wsx@tcp(47.116.127.1:3306)/wgserver?parseTime=true&charset=utf8mb4,utf8"),
+		Env:    getenv("APP_ENV", "dev"),
+
+		PersistBatchSize:     getenvInt("ROLE_PERSIST_BATCH", 100),
+		PersistFlushInterval: getenvDuration("ROLE_PERSIST_INTERVAL", time.Second),
+		PersistMaxRetries:    getenvNonNegInt("ROLE_PERSIST_RETRIES", 5),
+
+		RoleConflictPolicy: getenv("ROLE_CONFLICT_POLICY", "take_over"),
+		RoleRulesFile:      getenv("ROLE_RULES_FILE", ""),
+		RoleStaleAfter:     getenvDuration("ROLE_STALE_AFTER", 10*time.Minute),
+
+		MapRulesFile:   getenv("MAP_RULES_FILE", ""),
+		MapRulesReload: getenvDuration("MAP_RULES_RELOAD", 10*time.Second),
+
+		ComplianceArriveTimeout: getenvDuration("COMPLIANCE_ARRIVE_TIMEOUT", 10*time.Minute),
+		ComplianceWanderGrace:   getenvDuration("COMPLIANCE_WANDER_GRACE", 3*time.Minute),
+		ComplianceReassignAfter: getenvInt("COMPLIANCE_REASSIGN_AFTER", 0),
+		ComplianceReassignTTL:   getenvDuration("COMPLIANCE_REASSIGN_TTL", 2*time.Hour),
+
+		PlanHistoryRetention: getenvDuration("PLAN_HISTORY_RETENTION", 30*24*time.Hour),
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		var p int
+		_, _ = fmt.Sscanf(v, "%d", &p)
+		if p > 0 {
+			cfg.Port = p
+		}
+	}
+	return cfg
+}
+
+func (c *Config) ListenAddr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+// getenvInt 读取正整数环境变量，非法或未设置时返回默认值
+func getenvInt(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		var n int
+		_, _ = fmt.Sscanf(v, "%d", &n)
+		if n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// getenvNonNegInt 读取非负整数环境变量（0 有意义，如不重试），非法或未设置时返回默认值
+func getenvNonNegInt(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		var n int
+		if _, err := fmt.Sscanf(v, "%d", &n); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// getenvDuration 读取 time.ParseDuration 格式（如 "500ms"、"2s"）的环境变量
+func getenvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return def
+}
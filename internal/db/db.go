@@ -1,75 +1,113 @@
-package db
-
-import (
-	"fmt"
-	"time"
-
-	"wgserver/internal/config"
-
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
-)
-
-var xdb *sqlx.DB
-
-func Init(cfg *config.Config) error {
-	var err error
-	xdb, err = sqlx.Open("mysql", cfg.DBDSN)
-	if err != nil {
-		return err
-	}
-	xdb.SetConnMaxLifetime(4 * time.Minute)
-	xdb.SetMaxOpenConns(32)
-	xdb.SetMaxIdleConns(8)
-	return xdb.Ping()
-}
-
-func Close() {
-	if xdb != nil {
-		_ = xdb.Close()
-	}
-}
-
-func DB() *sqlx.DB { return xdb }
-
-func Tx(fn func(*sqlx.Tx) error) error {
-	tx, err := xdb.Beginx()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
-			panic(p)
-		}
-	}()
-	if err := fn(tx); err != nil {
-		_ = tx.Rollback()
-		return err
-	}
-	return tx.Commit()
-}
-
-// helpers
-func Placeholders(n int) string {
-	ph := make([]byte, 0, n*2)
-	for i := 0; i < n; i++ {
-		ph = append(ph, '?')
-		if i != n-1 {
-			ph = append(ph, ',')
-		}
-	}
-	return string(ph)
-}
-
-func InClause[T any](field string, vals []T) (string, []any) {
-	if len(vals) == 0 {
-		return "1=0", nil
-	}
-	q := fmt.Sprintf("%s IN (%s)", field, Placeholders(len(vals)))
-	args := make([]any, len(vals))
-	for i := range vals {
-		args[i] = any(vals[i])
-	}
-	return q, args
-}
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"wgserver/internal/config"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+var xdb *sqlx.DB
+
+func Init(cfg *config.Config) error {
+	var err error
+	xdb, err = sqlx.Open("mysql", cfg.DBDSN)
+	if err != nil {
+		return err
+	}
+	xdb.SetConnMaxLifetime(4 * time.Minute)
+	xdb.SetMaxOpenConns(32)
+	xdb.SetMaxIdleConns(8)
+	return xdb.Ping()
+}
+
+func Close() {
+	if xdb != nil {
+		_ = xdb.Close()
+	}
+}
+
+func DB() *sqlx.DB { return xdb }
+
+func Tx(fn func(*sqlx.Tx) error) error {
+	tx, err := xdb.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExecScript 在同一连接上依次执行 SQL 脚本中的语句（以行尾分号分隔，脚本内的用户变量与预处理语句因此可用）；
+// 跳过注释行与 USE 语句，库名以 DSN 为准
+func ExecScript(ctx context.Context, script string) error {
+	conn, err := xdb.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var stmt strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		stmt.WriteString(line)
+		stmt.WriteString("\n")
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+		q := strings.TrimSuffix(strings.TrimSpace(stmt.String()), ";")
+		stmt.Reset()
+		if strings.HasPrefix(strings.ToUpper(q), "USE ") {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			return fmt.Errorf("%.60s: %w", q, err)
+		}
+	}
+	if rest := strings.TrimSpace(stmt.String()); rest != "" {
+		if _, err := conn.ExecContext(ctx, rest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helpers
+func Placeholders(n int) string {
+	ph := make([]byte, 0, n*2)
+	for i := 0; i < n; i++ {
+		ph = append(ph, '?')
+		if i != n-1 {
+			ph = append(ph, ',')
+		}
+	}
+	return string(ph)
+}
+
+func InClause[T any](field string, vals []T) (string, []any) {
+	if len(vals) == 0 {
+		return "1=0", nil
+	}
+	q := fmt.Sprintf("%s IN (%s)", field, Placeholders(len(vals)))
+	args := make([]any, len(vals))
+	for i := range vals {
+		args[i] = any(vals[i])
+	}
+	return q, args
+}
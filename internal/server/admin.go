@@ -0,0 +1,349 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/alloc"
+	"wgserver/internal/services/compliance"
+	"wgserver/internal/services/roles"
+)
+
+// 管理接口：仅供内部运维查询，挂载在 /admin/ 下
+
+// GET /admin/roles/persist 角色写回队列深度与失败统计
+func (h *Hub) HandleAdminPersist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, roles.Instance().PersistStats())
+}
+
+// GET /admin/roles/quarantine 未通过属性校验、未参与规划的上报
+func (h *Hub) HandleAdminQuarantine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, roles.Instance().ListQuarantine())
+}
+
+type quarantineReleaseRequest struct {
+	Zone   string `json:"zone"`
+	Role   string `json:"role"`
+	Accept bool   `json:"accept"`
+}
+
+// POST /admin/roles/quarantine/release {"zone":"中州1区","role":"A","accept":true}
+// accept=true 采信该次上报并重新规划，false 丢弃
+func (h *Hub) HandleAdminQuarantineRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req quarantineReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ri, err := roles.Instance().ReleaseQuarantine(req.Zone, req.Role, req.Accept)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zone": req.Zone, "role": req.Role, "accepted": ri != nil})
+}
+
+type zoneMergeRequest struct {
+	Target     string   `json:"target"`
+	Sources    []string `json:"sources"`
+	MergeState string   `json:"merge_state"`
+}
+
+// POST /admin/zones/merge 合区：{"target":"中州1区","sources":["中州2区"],"merge_state":"一合"}
+func (h *Hub) HandleAdminZoneMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req zoneMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := mergeZones(req.Target, req.Sources, req.MergeState)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// GET /admin/zones 当前区服列表与别名
+func (h *Hub) HandleAdminZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type zoneInfo struct {
+		Zone       string `json:"zone"`
+		MergeState string `json:"merge_state,omitempty"`
+		Roles      int    `json:"roles"`
+		Offline    int    `json:"offline"`
+	}
+	m := roles.Instance()
+	zones := []zoneInfo{}
+	for _, z := range m.ListZones() {
+		zones = append(zones, zoneInfo{Zone: z, MergeState: m.MergeStateOverride(z), Roles: len(m.SnapshotZone(z).Roles), Offline: len(m.OfflineRoles(z))})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zones": zones, "aliases": m.ListAliases()})
+}
+
+// GET /admin/plans?zone=中州1区 当前分配计划及每条分配的说明（规则分支、排名、强度、竞争者）；
+// 不带 zone 时返回全部区服
+func (h *Hub) HandleAdminPlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type planInfo struct {
+		Zone        string             `json:"zone"`
+		Version     uint64             `json:"version"`
+		LastPlan    time.Time          `json:"last_plan"`
+		LastSend    time.Time          `json:"last_send"`
+		Moves       int                `json:"moves"`
+		Schedule    string             `json:"schedule,omitempty"`
+		History     int                `json:"history_version"`
+		Assignments []alloc.Assignment `json:"assignments"`
+	}
+	zone := r.URL.Query().Get("zone")
+	if zone != "" {
+		zone = roles.Instance().ResolveZone(zone)
+	}
+	planStates.mu.RLock()
+	out := []planInfo{}
+	for z, st := range planStates.m {
+		if zone != "" && z != zone {
+			continue
+		}
+		out = append(out, planInfo{Zone: z, Version: st.Version, LastPlan: st.LastPlan, LastSend: st.LastSend, Moves: st.Moves, Schedule: st.Schedule, History: st.History,
+			Assignments: append([]alloc.Assignment(nil), st.Assignments...)})
+	}
+	planStates.mu.RUnlock()
+	if zone != "" && len(out) == 0 {
+		http.Error(w, "no plan for zone "+zone, http.StatusNotFound)
+		return
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Zone < out[j].Zone })
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /admin/plans/compliance?zone=中州1区&roles=1 分配执行率：已到达/超时未到达/离开目标地图的人数、
+// 平均到达耗时；roles=1 时附带每个角色的记录。不带 zone 时返回全部区服
+func (h *Hub) HandleAdminCompliance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	zone := r.URL.Query().Get("zone")
+	if zone != "" {
+		zone = roles.Instance().ResolveZone(zone)
+	}
+	writeJSON(w, http.StatusOK, compliance.Instance().Stats(zone, r.URL.Query().Get("roles") == "1"))
+}
+
+// GET /admin/plans/history?zone=中州1区&at=2024-05-01 20:00:00 该时刻生效的计划（含各角色目标与相对上一版本的变化）；
+// 不带 at 时列出 since~until（默认最近 24 小时）内的各版本、触发原因与变化。
+// zone 按原名查询，合区前的历史使用旧区服名
+func (h *Hub) HandleAdminPlanHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	zone := q.Get("zone")
+	if zone == "" {
+		http.Error(w, "zone is required", http.StatusBadRequest)
+		return
+	}
+	if q.Get("at") != "" {
+		at, err := parseAdminTime(q.Get("at"), time.Time{})
+		if err != nil {
+			http.Error(w, "bad at: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		v, err := alloc.PlanAt(zone, at)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if v == nil {
+			http.Error(w, "no plan for zone "+zone+" at that time", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+		return
+	}
+	since, until, err := adminTimeRange(q.Get("since"), q.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vs, err := alloc.ListPlanVersions(zone, since, until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, vs)
+}
+
+// GET /admin/plans/timeline?zone=中州1区&role=A&since=...&until=... 角色的目标变化时间线（默认最近 24 小时），
+// 包含合区前在旧区服的记录
+func (h *Hub) HandleAdminRoleTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	zone, role := q.Get("zone"), q.Get("role")
+	if zone == "" || role == "" {
+		http.Error(w, "zone and role are required", http.StatusBadRequest)
+		return
+	}
+	since, until, err := adminTimeRange(q.Get("since"), q.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	zone = roles.Instance().ResolveZone(zone)
+	zones := []string{zone}
+	for _, a := range roles.Instance().ListAliases() {
+		if a.Zone == zone {
+			zones = append(zones, a.Alias)
+		}
+	}
+	entries, err := alloc.RoleTimeline(zones, role, since, until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zone": zone, "role": role, "timeline": entries})
+}
+
+// parseAdminTime 接受 RFC3339 或 UTC+8 的 "2006-01-02 15:04:05"；为空返回 def
+func parseAdminTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateTime, s, alloc.ScheduleLoc)
+}
+
+func adminTimeRange(sinceStr, untilStr string) (since, until time.Time, err error) {
+	now := time.Now()
+	if until, err = parseAdminTime(untilStr, now); err != nil {
+		return since, until, errors.New("bad until: " + err.Error())
+	}
+	if since, err = parseAdminTime(sinceStr, until.Add(-24*time.Hour)); err != nil {
+		return since, until, errors.New("bad since: " + err.Error())
+	}
+	return since, until, nil
+}
+
+// GET /admin/plans/fairness?zone=中州1区 公平轮换：区服权重与各角色最近 days 天在各层级地图的累计分配小时数
+func (h *Hub) HandleAdminFairness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	zone := r.URL.Query().Get("zone")
+	if zone == "" {
+		http.Error(w, "zone is required", http.StatusBadRequest)
+		return
+	}
+	zone = roles.Instance().ResolveZone(zone)
+	f := alloc.CurrentRules().Fairness
+	hours := map[string]map[string]float64{}
+	for role, byTier := range alloc.TierUsage(zone, time.Now()) {
+		hours[role] = map[string]float64{}
+		for tier, d := range byTier {
+			hours[role][tier] = math.Round(d.Hours()*100) / 100
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zone": zone, "weight": f.WeightFor(zone), "days": f.Days, "tiers": f.Tiers, "hours": hours})
+}
+
+type overrideRequest struct {
+	alloc.Override
+	TTL string `json:"ttl"` // 例如 "2h"；与 expires_at 都不填时默认 24h
+}
+
+// /admin/plans/overrides 人工干预：
+// GET ?zone= 列出未过期的 pin 与排除；
+// POST {"zone":"中州1区","kind":"pin","role":"A","map":"通天塔","floor":2,"ttl":"6h","note":"..."}，
+// kind=exclude 时 role、map 可只填其一；
+// DELETE {"zone":"中州1区","kind":"exclude","role":"A","map":"地下魔域"}。
+// 变更后已有计划的区服立即重新规划
+func (h *Hub) HandleAdminOverrides(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		zone := r.URL.Query().Get("zone")
+		if zone != "" {
+			zone = roles.Instance().ResolveZone(zone)
+		}
+		writeJSON(w, http.StatusOK, alloc.ListOverrides(zone, time.Now()))
+		return
+	case http.MethodPost, http.MethodDelete:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Zone = roles.Instance().ResolveZone(req.Zone)
+	var result any
+	if r.Method == http.MethodDelete {
+		if !alloc.RemoveOverride(req.Zone, req.Kind, req.Role, req.Map) {
+			http.Error(w, "override not found", http.StatusNotFound)
+			return
+		}
+		result = map[string]any{"zone": req.Zone, "removed": true}
+	} else {
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d <= 0 {
+				http.Error(w, "bad ttl", http.StatusBadRequest)
+				return
+			}
+			req.ExpiresAt = time.Now().Add(d)
+		}
+		o, err := alloc.SetOverride(req.Override)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result = o
+	}
+	if _, ok := planVersion(req.Zone); ok {
+		logger.MapAlloc().Printf("zone=%s replan triggered by admin override", req.Zone)
+		replanZone(req.Zone, "admin override")
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
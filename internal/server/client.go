@@ -1,23 +1,63 @@
-package server
-
-import (
-	"sync"
-	"time"
-
-	"github.com/gorilla/websocket"
-)
-
-type Client struct {
-	ID       string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	Zone     string
-	LastHBAt time.Time
-	mu       sync.Mutex
-}
-
-func (c *Client) SafeWrite(msg []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.Conn.WriteMessage(websocket.TextMessage, msg)
-}
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wgserver/internal/services/alloc"
+
+	"github.com/gorilla/websocket"
+)
+
+type Client struct {
+	ID       string
+	Conn     *websocket.Conn
+	Send     chan []byte
+	Zone     string
+	LastHBAt time.Time
+	Proto    atomic.Int32 // 客户端协议版本：连接参数 proto 或任意消息中的 协议版本，未上报为 1
+	// 分配只在目标变化时推送：连接参数 changes_only=1 或任意消息中的 仅推送变化
+	ChangesOnly atomic.Bool
+	mu          sync.Mutex
+
+	sentMu sync.Mutex
+	sent   map[string]sentAssignment // 角色 -> 最近一次推送给该连接的目标（层数按实际下发）与队伍
+}
+
+type sentAssignment struct {
+	target alloc.MapTarget
+	party  string
+}
+
+// 协议版本
+const (
+	protoLegacy      = 1 // 仅法师的分配消息携带 层数
+	protoFloorForAll = 2 // 所有职业的分配消息都携带 层数
+)
+
+// floorSupported 该客户端能否接收该职业的 层数
+func (c *Client) floorSupported(class string) bool {
+	return class == "法师" || c.Proto.Load() >= protoFloorForAll
+}
+
+// markSent 记录推送给该连接的目标与队伍，返回是否与上次不同
+func (c *Client) markSent(role string, target alloc.MapTarget, party *alloc.Party) bool {
+	cur := sentAssignment{target: target, party: party.String()}
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	if prev, ok := c.sent[role]; ok && prev == cur {
+		return false
+	}
+	if c.sent == nil {
+		c.sent = map[string]sentAssignment{}
+	}
+	c.sent[role] = cur
+	return true
+}
+
+func (c *Client) SafeWrite(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(websocket.TextMessage, msg)
+}
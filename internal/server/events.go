@@ -0,0 +1,64 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 订阅角色事件：已有分配计划的区服仅在影响规划的属性变化时重新规划，
+// 同一区服短时间内的多次变化合并为一次；角色离开时立即递补其名额，不整体重规划。
+const planDebounce = 2 * time.Second
+
+var pendingReplans = struct {
+	mu sync.Mutex
+	m  map[string]*time.Timer
+}{m: map[string]*time.Timer{}}
+
+func watchRoleEvents() {
+	ch, _ := roles.Instance().Subscribe(1024)
+	for ev := range ch {
+		// 合区由 mergeZones 统一重规划
+		if ev.Kind == roles.EventRoleRemoved && ev.Reason != "merge" {
+			fillVacancies(ev.Zone)
+			continue
+		}
+		if !affectsPlan(ev.Kind) {
+			continue
+		}
+		// 首次规划仍由人数阈值/等待期触发
+		if _, _, _, ok := getPlanStateSnapshot(ev.Zone); !ok {
+			continue
+		}
+		scheduleReplan(ev.Zone, string(ev.Kind))
+	}
+}
+
+func affectsPlan(k roles.EventKind) bool {
+	switch k {
+	case roles.EventRoleAdded, roles.EventEquipmentChanged, roles.EventLevelThreshold, roles.EventSkillThreshold, roles.EventLuckThreshold:
+		return true
+	}
+	return false
+}
+
+func scheduleReplan(zone, reason string) {
+	pendingReplans.mu.Lock()
+	defer pendingReplans.mu.Unlock()
+	if _, ok := pendingReplans.m[zone]; ok {
+		return
+	}
+	pendingReplans.m[zone] = time.AfterFunc(planDebounce, func() {
+		pendingReplans.mu.Lock()
+		delete(pendingReplans.m, zone)
+		pendingReplans.mu.Unlock()
+		// 期间已被其他路径按最新快照重规划过则跳过
+		if v, ok := planVersion(zone); ok && v == roles.Instance().SnapshotVersion(zone) {
+			return
+		}
+		logger.MapAlloc().Printf("zone=%s replan triggered by %s", zone, reason)
+		replanZone(zone, reason)
+	})
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/alloc"
+	"wgserver/internal/services/roles"
+	msgtypes "wgserver/internal/types"
+)
+
+// 客户端主动查询当前分配：直接读取 planStates 回复目标、层数、计划版本与下次规划时间，
+// 重启后的客户端无需等待下一轮推送。
+
+func (h *Hub) handleQueryAssignment(c *Client, data []byte) {
+	var q QueryAssignment
+	if err := json.Unmarshal(data, &q); err != nil {
+		SendJSON(c.ID, AssignmentReply{MsgType: string(msgtypes.MsgTypeQueryAssignment), Status: "格式错误", ClientID: c.ID})
+		logger.MapAlloc().Printf("assignment query client_id=%s bad payload: %v", c.ID, err)
+		return
+	}
+	if q.Zone == "" {
+		q.Zone = q.MergedZone
+	}
+	zones := roles.Instance().ListZones()
+	if q.Zone != "" {
+		zones = []string{roles.Instance().ResolveZone(q.Zone)}
+	}
+	found := false
+	for _, z := range zones {
+		snap := roles.Instance().SnapshotZone(z)
+		names := make([]string, 0, len(snap.ClientByRole))
+		for role, cid := range snap.ClientByRole {
+			if cid == c.ID && (q.RoleName == "" || role == q.RoleName) {
+				names = append(names, role)
+			}
+		}
+		sort.Strings(names)
+		for _, role := range names {
+			found = true
+			SendJSON(c.ID, assignmentReply(c, z, snap, role))
+		}
+	}
+	// 查询总有回复：指定角色未找到为 未知角色，未指定角色且该连接在所查区服没有角色为 无角色
+	if !found {
+		reply := AssignmentReply{MsgType: string(msgtypes.MsgTypeQueryAssignment), RoleName: q.RoleName, Zone: q.Zone, Status: "未知角色", ClientID: c.ID}
+		if q.RoleName == "" {
+			reply.Status = "无角色"
+		}
+		SendJSON(c.ID, reply)
+	}
+	logger.MapAlloc().Printf("assignment query client_id=%s role=%s zone=%s found=%v", c.ID, q.RoleName, q.Zone, found)
+}
+
+// assignmentReply 按区服计划状态生成单个角色的查询结果，并记为已推送（仅推送变化的连接不再重复下发）
+func assignmentReply(c *Client, zone string, snap *roles.ZoneState, role string) AssignmentReply {
+	reply := AssignmentReply{MsgType: string(msgtypes.MsgTypeQueryAssignment), RoleName: role, Zone: zone, ClientID: c.ID}
+	planStates.mu.RLock()
+	state := planStates.m[zone]
+	var (
+		target   alloc.MapTarget
+		party    *alloc.Party
+		assigned bool
+		lastPlan time.Time
+		version  int
+	)
+	if state != nil {
+		lastPlan, version = state.LastPlan, state.History
+		for _, a := range state.Assignments {
+			if a.RoleName == role {
+				target, party, assigned = a.Target, a.Party, true
+				break
+			}
+		}
+	}
+	planStates.mu.RUnlock()
+	if state == nil {
+		reply.Status = "未规划"
+		if snap.WaitAllocUntil.After(time.Now()) {
+			reply.Data.NextPlan = snap.WaitAllocUntil.In(alloc.ScheduleLoc).Format(time.DateTime)
+		}
+		return reply
+	}
+	reply.Data.PlanVersion = version
+	reply.Data.NextPlan = nextPlanAt(zone, lastPlan, time.Now()).In(alloc.ScheduleLoc).Format(time.DateTime)
+	if !assigned {
+		reply.Status = "未分配"
+		return reply
+	}
+	reply.Status = "已分配"
+	reply.Data.Map = target.Map
+	if ri, ok := snap.Roles[role]; ok && target.Floor > 0 && c.floorSupported(ri.Class) {
+		reply.Data.Floor = target.Floor
+	}
+	reply.Data.Party = partyRoster(party)
+	c.markSent(role, alloc.MapTarget{Map: reply.Data.Map, Floor: reply.Data.Floor}, party)
+	return reply
+}
+
+// nextPlanAt 下一次确定会发生的重规划：定时重规划、时段窗口切换与人工干预到期中最早者
+// （角色上下线、规则热加载等事件触发的重规划无法预知）
+func nextPlanAt(zone string, lastPlan, now time.Time) time.Time {
+	next := lastPlan.Add(planRecalcInterval)
+	if t, ok := alloc.NextScheduleChange(now, next); ok {
+		next = t
+	}
+	for _, o := range alloc.ListOverrides(zone, now) {
+		if o.ExpiresAt.Before(next) {
+			next = o.ExpiresAt
+		}
+	}
+	return next
+}
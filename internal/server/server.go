@@ -1,451 +1,679 @@
-package server
-
-import (
-	"encoding/json"
-	"fmt"
-	"math/rand"
-	"net/http"
-	"strings"
-	"sync"
-	"time"
-
-	"wgserver/internal/logger"
-	"wgserver/internal/services/alloc"
-	eq "wgserver/internal/services/equipment"
-	"wgserver/internal/services/roles"
-	"wgserver/internal/services/tasks"
-	msgtypes "wgserver/internal/types"
-
-	"github.com/gorilla/websocket"
-)
-
-type Hub struct {
-	clients   map[string]*Client
-	clientsMu sync.RWMutex
-	upgrader  websocket.Upgrader
-
-	// heartbeats
-	hbInterval   time.Duration
-	maxHBNoReply time.Duration
-}
-
-var defaultHub *Hub
-
-// 记录上一次对某个 充值区服|角色 推送的副本目标，用于变更日志去重
-var lastAssign = struct {
-	mu sync.Mutex
-	m  map[string]alloc.MapTarget
-}{m: map[string]alloc.MapTarget{}}
-
-const (
-	planRecalcInterval    = 3 * time.Hour
-	planBroadcastInterval = time.Minute
-)
-
-type zonePlanState struct {
-	Assignments []alloc.Assignment
-	LastPlan    time.Time
-	LastSend    time.Time
-}
-
-var planStates = struct {
-	mu sync.RWMutex
-	m  map[string]*zonePlanState
-}{m: map[string]*zonePlanState{}}
-
-func updatePlanState(zone string, assignments []alloc.Assignment, planTime time.Time) {
-	copied := make([]alloc.Assignment, len(assignments))
-	copy(copied, assignments)
-	planStates.mu.Lock()
-	defer planStates.mu.Unlock()
-	state := planStates.m[zone]
-	if state == nil {
-		state = &zonePlanState{}
-		planStates.m[zone] = state
-	}
-	state.Assignments = copied
-	state.LastPlan = planTime
-	state.LastSend = time.Time{}
-}
-
-func markPlanSent(zone string, sentAt time.Time) {
-	planStates.mu.Lock()
-	if state := planStates.m[zone]; state != nil {
-		state.LastSend = sentAt
-	}
-	planStates.mu.Unlock()
-}
-
-func getPlanStateSnapshot(zone string) (assignments []alloc.Assignment, lastPlan, lastSend time.Time, ok bool) {
-	planStates.mu.RLock()
-	state, ok := planStates.m[zone]
-	if !ok {
-		planStates.mu.RUnlock()
-		return nil, time.Time{}, time.Time{}, false
-	}
-	copied := make([]alloc.Assignment, len(state.Assignments))
-	copy(copied, state.Assignments)
-	lastPlan = state.LastPlan
-	lastSend = state.LastSend
-	planStates.mu.RUnlock()
-	return copied, lastPlan, lastSend, true
-}
-
-func clearPlanState(zone string) {
-	planStates.mu.Lock()
-	delete(planStates.m, zone)
-	planStates.mu.Unlock()
-}
-
-func NewHub(cfg interface{}) *Hub {
-	defaultHub = &Hub{
-		clients: make(map[string]*Client),
-		upgrader: websocket.Upgrader{
-			ReadBufferSize:  4096,
-			WriteBufferSize: 4096,
-			CheckOrigin:     func(r *http.Request) bool { return true },
-		},
-		hbInterval:   30 * time.Second,
-		maxHBNoReply: 3 * time.Minute,
-	}
-	// inject sender for tasks
-	tasks.SetSender(SendJSON)
-	// inject sender for equipment exchanges
-	eq.SetSender(SendJSON)
-	// global planner loop: keep minute broadcasts and 3h replans per zone
-	go func() {
-		t := time.NewTicker(planBroadcastInterval)
-		defer t.Stop()
-		for tick := range t.C {
-			zones := roles.Instance().ListZones()
-			for _, z := range zones {
-				snap := roles.Instance().SnapshotZone(z)
-				if len(snap.Roles) == 0 {
-					clearPlanState(z)
-					continue
-				}
-				mergeState := mergeStateFromSnapshot(snap)
-				need := neededByMerge(mergeState)
-				thresholdMet := len(snap.Roles) >= need
-				waitExpired := tick.After(snap.WaitAllocUntil)
-
-				assignments, lastPlan, lastSend, hasState := getPlanStateSnapshot(z)
-				shouldPlan := false
-				if !hasState {
-					if thresholdMet || waitExpired {
-						shouldPlan = true
-					}
-				} else if tick.Sub(lastPlan) >= planRecalcInterval {
-					shouldPlan = true
-				}
-
-				if shouldPlan {
-					assignments = alloc.Plan(z)
-					updatePlanState(z, assignments, tick)
-					lastSend = time.Time{}
-				}
-
-				if len(assignments) == 0 {
-					continue
-				}
-
-				if shouldPlan || tick.Sub(lastSend) >= planBroadcastInterval {
-					dispatchAssignments(snap, assignments)
-					markPlanSent(z, time.Now())
-				}
-			}
-		}
-	}()
-	return defaultHub
-}
-func (h *Hub) HandleWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		return
-	}
-
-	id := h.newClientID()
-	c := &Client{ID: id, Conn: conn, Send: make(chan []byte, 64), LastHBAt: time.Now()}
-	h.clientsMu.Lock()
-	h.clients[id] = c
-	h.clientsMu.Unlock()
-	// 连接事件：记录当前总连接数
-	h.clientsMu.RLock()
-	total := len(h.clients)
-	h.clientsMu.RUnlock()
-	logger.Connection().Printf("connected client_id=%s from %s total=%d", id, r.RemoteAddr, total)
-	ack := ConnectionAck{Code: 200, Message: "成功", Type: string(msgtypes.MsgTypeConnectionAck), ClientID: id}
-	_ = conn.WriteJSON(ack)
-
-	go h.writer(c)
-	go h.reader(c)
-	go h.heartbeatSender(c)
-}
-
-func (h *Hub) newClientID() string {
-	return strings.ToUpper(randStr(8)) + "-" + strings.ToUpper(randStr(4))
-}
-
-func randStr(n int) string {
-	letters := []rune("0123456789abcdefghijklmnopqrstuvwxyz")
-	s := make([]rune, n)
-	for i := range s {
-		s[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(s)
-}
-
-func (h *Hub) reader(c *Client) {
-	defer h.disconnect(c)
-	c.Conn.SetReadLimit(1 << 20)
-	for {
-		mt, data, err := c.Conn.ReadMessage()
-		if err != nil {
-			return
-		}
-		if mt != websocket.TextMessage {
-			continue
-		}
-		// try detect heartbeat response
-		var base map[string]any
-		if err := json.Unmarshal(data, &base); err == nil {
-			if typ, ok := base["type"].(string); ok && typ == string(msgtypes.MsgTypeHeartbeatResponse) {
-				c.LastHBAt = time.Now()
-				continue
-			}
-			if st, ok := base["status"].(string); ok && st == "received" {
-				// 通用ACK：记录并标注该客户端关联角色的分配已被确认
-				logger.Connection().Printf("ack received from client_id=%s", c.ID)
-				onAckReceived(c.ID)
-				continue
-			}
-		}
-		// handle other messages
-		h.handleMessage(c, data)
-	}
-}
-
-func (h *Hub) writer(c *Client) {
-	defer h.disconnect(c)
-	for msg := range c.Send {
-		if err := c.SafeWrite(msg); err != nil {
-			return
-		}
-	}
-}
-
-func (h *Hub) disconnect(c *Client) {
-	c.mu.Lock()
-	_ = c.Conn.Close()
-	c.mu.Unlock()
-
-	h.clientsMu.Lock()
-	delete(h.clients, c.ID)
-	h.clientsMu.Unlock()
-
-	// 清理该客户端角色信息（调用角色服务进行清理）
-	onClientDisconnected(c.ID)
-	h.clientsMu.RLock()
-	total := len(h.clients)
-	h.clientsMu.RUnlock()
-	logger.Connection().Printf("disconnected client_id=%s total=%d", c.ID, total)
-}
-
-func (h *Hub) heartbeatSender(c *Client) {
-	t := time.NewTicker(h.hbInterval)
-	defer t.Stop()
-	for range t.C {
-		// send heartbeat
-		hb := Heartbeat{Type: string(msgtypes.MsgTypeHeartbeat), ClientID: c.ID}
-		b, _ := json.Marshal(hb)
-		select {
-		case c.Send <- b:
-		default:
-		}
-		// check stale
-		if time.Since(c.LastHBAt) > h.maxHBNoReply {
-			logger.Connection().Printf("client_id=%s heartbeat timeout; closing", c.ID)
-			c.Conn.Close()
-			return
-		}
-	}
-}
-
-func (h *Hub) handleMessage(c *Client, data []byte) {
-	// dispatch by content
-	var obj map[string]any
-	if err := json.Unmarshal(data, &obj); err != nil {
-		return
-	}
-
-	// 日常任务
-	if mt, ok := obj["消息类型"].(string); ok && mt == string(msgtypes.MsgTypeDailyTask) {
-		h.handleDailyTaskMessage(c, data)
-		return
-	}
-	// 装备交换确认/坐标/等 按字段判断
-	if _, ok := obj["操作"].(string); ok {
-		h.handleExchangeConfirmation(c, data)
-		return
-	}
-	if _, ok := obj["地图"].(string); ok && obj["X"] != nil && obj["Y"] != nil && obj["来源角色"] != nil {
-		h.handleExchangeCoordinate(c, data)
-		return
-	}
-	// 角色属性
-	h.handleRoleAttributes(c, data)
-}
-
-// stubs wired to services (implemented in other files)
-func (h *Hub) handleDailyTaskMessage(c *Client, data []byte) {
-	if msg, ok := tasks.ParseDailyTaskMessage(data); ok {
-		tasks.Instance().Handle(msg)
-	}
-}
-func (h *Hub) handleExchangeConfirmation(c *Client, data []byte) {
-	// 将确认消息交给装备交换管理器，遍历区服匹配
-	zones := roles.Instance().ListZones()
-	for _, z := range zones {
-		eq.HandleConfirm(z, data)
-	}
-}
-func (h *Hub) handleExchangeCoordinate(c *Client, data []byte) {
-	zones := roles.Instance().ListZones()
-	for _, z := range zones {
-		eq.HandleCoordinate(z, data)
-	}
-}
-func (h *Hub) handleRoleAttributes(c *Client, data []byte) {
-	info, _ := roles.Instance().UpsertRole(data)
-	if info == nil {
-		return
-	}
-	// trigger planning when role count sufficient or when wait deadline passed
-	snap := roles.Instance().SnapshotZone(info.Zone)
-	need := neededByMerge(info.MergeState)
-	if len(snap.Roles) >= need || time.Now().After(snap.WaitAllocUntil) {
-		planTime := time.Now()
-		as := alloc.Plan(info.Zone)
-		updatePlanState(info.Zone, as, planTime)
-		if len(as) > 0 {
-			dispatchAssignments(snap, as)
-			markPlanSent(info.Zone, time.Now())
-		}
-		// 同步触发装备分配与交换事务
-		eq.PlanAndDispatch(info.Zone)
-	}
-}
-
-// called on disconnect to cleanup roles owned by client
-func onClientDisconnected(clientID string) { roles.Instance().RemoveClient(clientID) }
-
-// 标注ACK：将该client_id关联的所有角色分配视为已被确认（仅做日志记录，周期间仍会按策略推送）
-func onAckReceived(clientID string) {
-	zones := roles.Instance().ListZones()
-	for _, z := range zones {
-		snap := roles.Instance().SnapshotZone(z)
-		for role, cid := range snap.ClientByRole {
-			if cid == clientID {
-				logger.MapAlloc().Printf("ack confirmed zone=%s role=%s client_id=%s", z, role, clientID)
-			}
-		}
-	}
-}
-
-func neededByMerge(ms string) int {
-	switch ms {
-	case "未合区":
-		return 12
-	case "一合", "一合区", "第一次合区":
-		return 24
-	case "二合", "三合", "四合", "五合", "六合":
-		return 48
-	case "七合", "七合以后":
-		return 28
-	default:
-		return 12
-	}
-}
-
-// Global accessors
-func HubInstance() *Hub { return defaultHub }
-
-// Send JSON to a client by id (non-blocking)
-func SendJSON(clientID string, v any) {
-	h := HubInstance()
-	if h == nil {
-		return
-	}
-	h.clientsMu.RLock()
-	c := h.clients[clientID]
-	h.clientsMu.RUnlock()
-	if c == nil {
-		return
-	}
-	b, _ := json.Marshal(v)
-	select {
-	case c.Send <- b:
-	default:
-	}
-}
-
-// 仅在分配目标变化时记录： 角色名 原先副本地图----->规划副本地图
-func logMapAssignIfChanged(snap *roles.ZoneState, role string, target alloc.MapTarget) {
-	// zone 取自该角色所在快照（ClientByRole 的 key 未显式包含 zone，这里取一个快照字段不足以得到 zone 名，
-	// 因此从角色对象读取充值区服更稳妥）
-	zone := ""
-	if rr, ok := snap.Roles[role]; ok {
-		zone = rr.Zone
-	}
-	key := zone + "|" + role
-	lastAssign.mu.Lock()
-	prev, ok := lastAssign.m[key]
-	same := ok && prev.Map == target.Map && prev.Floor == target.Floor
-	if !same {
-		prevStr := prev.Map
-		if prev.Floor > 0 {
-			prevStr = prevStr + formatFloor(prev.Floor)
-		}
-		nowStr := target.Map
-		if target.Floor > 0 {
-			nowStr = nowStr + formatFloor(target.Floor)
-		}
-		logger.MapAlloc().Printf("role=%s prev=%s -> plan=%s", role, nonEmpty(prevStr, "(无)"), nowStr)
-		lastAssign.m[key] = target
-	}
-	lastAssign.mu.Unlock()
-}
-
-func formatFloor(f int) string { return "-" + itoa(f) }
-func dispatchAssignments(snap *roles.ZoneState, assignments []alloc.Assignment) {
-	for _, a := range assignments {
-		cid := snap.ClientByRole[a.RoleName]
-		if cid == "" {
-			continue
-		}
-		msg := MapAssignment{RoleName: a.RoleName, ClientID: cid}
-		msg.Data.Map = a.Target.Map
-		if ri, ok := snap.Roles[a.RoleName]; ok && ri.Class == "法师" && a.Target.Floor > 0 {
-			msg.Data.Floor = a.Target.Floor
-		}
-		logMapAssignIfChanged(snap, a.RoleName, a.Target)
-		SendJSON(cid, msg)
-	}
-}
-
-func mergeStateFromSnapshot(zs *roles.ZoneState) string {
-	for _, r := range zs.Roles {
-		if r.MergeState != "" {
-			return r.MergeState
-		}
-	}
-	return "未合区"
-}
-
-func itoa(v int) string { return fmt.Sprintf("%d", v) }
-func nonEmpty(s, alt string) string {
-	if s == "" {
-		return alt
-	}
-	return s
-}
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/alloc"
+	"wgserver/internal/services/compliance"
+	eq "wgserver/internal/services/equipment"
+	"wgserver/internal/services/roles"
+	"wgserver/internal/services/tasks"
+	msgtypes "wgserver/internal/types"
+
+	"github.com/gorilla/websocket"
+)
+
+type Hub struct {
+	clients   map[string]*Client
+	clientsMu sync.RWMutex
+	upgrader  websocket.Upgrader
+
+	// heartbeats
+	hbInterval   time.Duration
+	maxHBNoReply time.Duration
+}
+
+var defaultHub *Hub
+
+// 记录上一次对某个 充值区服|角色 推送的副本目标，用于变更日志去重
+var lastAssign = struct {
+	mu sync.Mutex
+	m  map[string]alloc.MapTarget
+}{m: map[string]alloc.MapTarget{}}
+
+const (
+	planRecalcInterval    = 3 * time.Hour
+	planBroadcastInterval = time.Minute
+)
+
+type zonePlanState struct {
+	Assignments []alloc.Assignment
+	LastPlan    time.Time
+	LastSend    time.Time
+	Version     uint64 // 规划所依据的角色快照版本
+	Moves       int    // 本轮相对上一轮目标变化的角色数
+	Schedule    string // 规划时生效的时段规则
+	History     int    // 规划历史版本号（见 alloc.RecordPlan）
+}
+
+var planStates = struct {
+	mu sync.RWMutex
+	m  map[string]*zonePlanState
+}{m: map[string]*zonePlanState{}}
+
+// 同一区服的规划串行执行：定时循环、角色事件（递补与防抖重规划）、管理接口与首次规划都会规划同一区服，
+// 从读取上一轮计划到 updatePlanState 与推送全程持有该区服的锁，避免旧数据算出的计划覆盖新计划、历史版本交错
+var planLocks = struct {
+	mu sync.Mutex
+	m  map[string]*sync.Mutex
+}{m: map[string]*sync.Mutex{}}
+
+// lockZonePlan 获取区服的规划锁，返回解锁函数
+func lockZonePlan(zone string) (unlock func()) {
+	planLocks.mu.Lock()
+	l := planLocks.m[zone]
+	if l == nil {
+		l = &sync.Mutex{}
+		planLocks.m[zone] = l
+	}
+	planLocks.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// updatePlanState 保存新计划并记入规划历史，reason 为触发原因；调用方需持有该区服的规划锁
+func updatePlanState(zone, reason string, res alloc.PlanResult, planTime time.Time, version uint64) {
+	copied := make([]alloc.Assignment, len(res.Assignments))
+	copy(copied, res.Assignments)
+	hv := alloc.RecordPlan(zone, reason, res, time.Now())
+	planStates.mu.Lock()
+	defer planStates.mu.Unlock()
+	state := planStates.m[zone]
+	if state == nil {
+		state = &zonePlanState{}
+		planStates.m[zone] = state
+	}
+	state.Assignments = copied
+	state.LastPlan = planTime
+	state.LastSend = time.Time{}
+	state.Version = version
+	state.Moves = res.Moves
+	state.Schedule = res.Schedule
+	state.History = hv.Version
+}
+
+func markPlanSent(zone string, sentAt time.Time) {
+	planStates.mu.Lock()
+	if state := planStates.m[zone]; state != nil {
+		state.LastSend = sentAt
+	}
+	planStates.mu.Unlock()
+}
+
+func getPlanStateSnapshot(zone string) (assignments []alloc.Assignment, lastPlan, lastSend time.Time, ok bool) {
+	planStates.mu.RLock()
+	state, ok := planStates.m[zone]
+	if !ok {
+		planStates.mu.RUnlock()
+		return nil, time.Time{}, time.Time{}, false
+	}
+	copied := make([]alloc.Assignment, len(state.Assignments))
+	copy(copied, state.Assignments)
+	lastPlan = state.LastPlan
+	lastSend = state.LastSend
+	planStates.mu.RUnlock()
+	return copied, lastPlan, lastSend, true
+}
+
+// planVersion 返回当前计划所依据的快照版本
+func planVersion(zone string) (uint64, bool) {
+	planStates.mu.RLock()
+	defer planStates.mu.RUnlock()
+	if state, ok := planStates.m[zone]; ok {
+		return state.Version, true
+	}
+	return 0, false
+}
+
+func clearPlanState(zone string) {
+	planStates.mu.Lock()
+	delete(planStates.m, zone)
+	planStates.mu.Unlock()
+}
+
+func NewHub(cfg interface{}) *Hub {
+	defaultHub = &Hub{
+		clients: make(map[string]*Client),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			CheckOrigin:     func(r *http.Request) bool { return true },
+		},
+		hbInterval:   30 * time.Second,
+		maxHBNoReply: 3 * time.Minute,
+	}
+	// inject sender for tasks
+	tasks.SetSender(SendJSON)
+	// inject sender for equipment exchanges
+	eq.SetSender(SendJSON)
+	// inject sender for role ownership conflicts
+	roles.SetSender(SendJSON)
+	// incremental replans driven by role change events
+	go watchRoleEvents()
+	// global planner loop: keep minute broadcasts, 3h replans per zone and replans on schedule windows
+	go func() {
+		t := time.NewTicker(planBroadcastInterval)
+		defer t.Stop()
+		schedule := alloc.ScheduleName(time.Now())
+		for tick := range t.C {
+			// 时段窗口开始或结束时立即重新规划
+			if cur := alloc.ScheduleName(tick); cur != schedule {
+				ReplanAll(fmt.Sprintf("schedule %q -> %q", schedule, cur))
+				schedule = cur
+			}
+			// 长时间未上报的角色转为离线，不再占用副本名额
+			roles.Instance().ExpireStale(tick)
+			// 到期的 pin/排除失效后立即重新规划
+			for _, z := range alloc.ExpireOverrides(tick) {
+				if _, ok := planVersion(z); ok {
+					logger.MapAlloc().Printf("zone=%s replan triggered by override expiry", z)
+					replanZone(z, "override expiry")
+				}
+			}
+			reassignNonCompliant(tick)
+			if tick.Minute() == 0 {
+				alloc.PruneHistory(tick)
+			}
+			zones := roles.Instance().ListZones()
+			for _, z := range zones {
+				unlock := lockZonePlan(z)
+				planTick(z, tick)
+				unlock()
+			}
+		}
+	}()
+	return defaultHub
+}
+
+// planTick 定时循环中处理一个区服：首次规划、3 小时重规划与按分钟推送；调用方需持有该区服的规划锁
+func planTick(z string, tick time.Time) {
+	snap := roles.Instance().SnapshotZone(z)
+	if len(snap.Roles) == 0 {
+		clearPlanState(z)
+		return
+	}
+	mergeState := mergeStateFromSnapshot(snap)
+	need := neededByMerge(mergeState)
+	thresholdMet := len(snap.Roles) >= need
+	waitExpired := tick.After(snap.WaitAllocUntil)
+
+	assignments, lastPlan, lastSend, hasState := getPlanStateSnapshot(z)
+	shouldPlan, reason := false, ""
+	if !hasState {
+		if thresholdMet || waitExpired {
+			shouldPlan, reason = true, "initial"
+		}
+	} else if tick.Sub(lastPlan) >= planRecalcInterval {
+		shouldPlan, reason = true, "periodic"
+	}
+
+	if shouldPlan {
+		res := alloc.PlanSnapshot(z, snap, assignments)
+		assignments = res.Assignments
+		updatePlanState(z, reason, res, tick, snap.Version)
+		lastSend = time.Time{}
+	}
+
+	if len(assignments) == 0 {
+		return
+	}
+
+	if shouldPlan || tick.Sub(lastSend) >= planBroadcastInterval {
+		dispatchAssignments(snap, assignments)
+		markPlanSent(z, time.Now())
+	}
+}
+
+func (h *Hub) HandleWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	id := h.newClientID()
+	c := &Client{ID: id, Conn: conn, Send: make(chan []byte, 64), LastHBAt: time.Now()}
+	c.Proto.Store(protoLegacy)
+	if v, err := strconv.Atoi(r.URL.Query().Get("proto")); err == nil && v > 0 {
+		c.Proto.Store(int32(v))
+	}
+	c.ChangesOnly.Store(r.URL.Query().Get("changes_only") == "1")
+	h.clientsMu.Lock()
+	h.clients[id] = c
+	h.clientsMu.Unlock()
+	// 连接事件：记录当前总连接数
+	h.clientsMu.RLock()
+	total := len(h.clients)
+	h.clientsMu.RUnlock()
+	logger.Connection().Printf("connected client_id=%s from %s total=%d", id, r.RemoteAddr, total)
+	ack := ConnectionAck{Code: 200, Message: "成功", Type: string(msgtypes.MsgTypeConnectionAck), ClientID: id}
+	_ = conn.WriteJSON(ack)
+
+	go h.writer(c)
+	go h.reader(c)
+	go h.heartbeatSender(c)
+}
+
+func (h *Hub) newClientID() string {
+	return strings.ToUpper(randStr(8)) + "-" + strings.ToUpper(randStr(4))
+}
+
+func randStr(n int) string {
+	letters := []rune("0123456789abcdefghijklmnopqrstuvwxyz")
+	s := make([]rune, n)
+	for i := range s {
+		s[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(s)
+}
+
+func (h *Hub) reader(c *Client) {
+	defer h.disconnect(c)
+	c.Conn.SetReadLimit(1 << 20)
+	for {
+		mt, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if mt != websocket.TextMessage {
+			continue
+		}
+		// try detect heartbeat response
+		var base map[string]any
+		if err := json.Unmarshal(data, &base); err == nil {
+			if typ, ok := base["type"].(string); ok && typ == string(msgtypes.MsgTypeHeartbeatResponse) {
+				c.LastHBAt = time.Now()
+				continue
+			}
+			if st, ok := base["status"].(string); ok && st == "received" {
+				// 通用ACK：记录并标注该客户端关联角色的分配已被确认
+				logger.Connection().Printf("ack received from client_id=%s", c.ID)
+				onAckReceived(c.ID)
+				continue
+			}
+		}
+		// handle other messages
+		h.handleMessage(c, data)
+	}
+}
+
+func (h *Hub) writer(c *Client) {
+	defer h.disconnect(c)
+	for msg := range c.Send {
+		if err := c.SafeWrite(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (h *Hub) disconnect(c *Client) {
+	c.mu.Lock()
+	_ = c.Conn.Close()
+	c.mu.Unlock()
+
+	h.clientsMu.Lock()
+	delete(h.clients, c.ID)
+	h.clientsMu.Unlock()
+
+	// 清理该客户端角色信息（调用角色服务进行清理）
+	onClientDisconnected(c.ID)
+	h.clientsMu.RLock()
+	total := len(h.clients)
+	h.clientsMu.RUnlock()
+	logger.Connection().Printf("disconnected client_id=%s total=%d", c.ID, total)
+}
+
+func (h *Hub) heartbeatSender(c *Client) {
+	t := time.NewTicker(h.hbInterval)
+	defer t.Stop()
+	for range t.C {
+		// send heartbeat
+		hb := Heartbeat{Type: string(msgtypes.MsgTypeHeartbeat), ClientID: c.ID}
+		b, _ := json.Marshal(hb)
+		select {
+		case c.Send <- b:
+		default:
+		}
+		// check stale
+		if time.Since(c.LastHBAt) > h.maxHBNoReply {
+			logger.Connection().Printf("client_id=%s heartbeat timeout; closing", c.ID)
+			c.Conn.Close()
+			return
+		}
+	}
+}
+
+func (h *Hub) handleMessage(c *Client, data []byte) {
+	// dispatch by content
+	var obj map[string]any
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return
+	}
+	if v, ok := obj["协议版本"].(float64); ok && v > 0 {
+		c.Proto.Store(int32(v))
+	}
+	if v, ok := obj["仅推送变化"].(bool); ok {
+		c.ChangesOnly.Store(v)
+	}
+
+	// 查询当前分配
+	if mt, ok := obj["消息类型"].(string); ok && mt == string(msgtypes.MsgTypeQueryAssignment) {
+		h.handleQueryAssignment(c, data)
+		return
+	}
+	// 日常任务
+	if mt, ok := obj["消息类型"].(string); ok && mt == string(msgtypes.MsgTypeDailyTask) {
+		h.handleDailyTaskMessage(c, data)
+		return
+	}
+	// 装备交换确认/坐标/等 按字段判断
+	if _, ok := obj["操作"].(string); ok {
+		h.handleExchangeConfirmation(c, data)
+		return
+	}
+	if _, ok := obj["地图"].(string); ok && obj["X"] != nil && obj["Y"] != nil && obj["来源角色"] != nil {
+		h.handleExchangeCoordinate(c, data)
+		return
+	}
+	// 角色属性
+	h.handleRoleAttributes(c, data)
+}
+
+// stubs wired to services (implemented in other files)
+func (h *Hub) handleDailyTaskMessage(c *Client, data []byte) {
+	if msg, ok := tasks.ParseDailyTaskMessage(data); ok {
+		tasks.Instance().Handle(msg)
+	}
+}
+func (h *Hub) handleExchangeConfirmation(c *Client, data []byte) {
+	// 将确认消息交给装备交换管理器，遍历区服匹配
+	zones := roles.Instance().ListZones()
+	for _, z := range zones {
+		eq.HandleConfirm(z, data)
+	}
+}
+func (h *Hub) handleExchangeCoordinate(c *Client, data []byte) {
+	zones := roles.Instance().ListZones()
+	for _, z := range zones {
+		eq.HandleCoordinate(z, data)
+	}
+}
+func (h *Hub) handleRoleAttributes(c *Client, data []byte) {
+	info, _ := roles.Instance().UpsertRole(data)
+	if info == nil {
+		return
+	}
+	// 已有计划的区服由角色事件驱动增量重规划（见 events.go），这里只负责首次规划
+	if _, _, _, ok := getPlanStateSnapshot(info.Zone); ok {
+		return
+	}
+	// trigger planning when role count sufficient or when wait deadline passed
+	snap := roles.Instance().SnapshotZone(info.Zone)
+	need := neededByMerge(info.MergeState)
+	if len(snap.Roles) >= need || time.Now().After(snap.WaitAllocUntil) {
+		planInitial(info.Zone)
+	}
+}
+
+// planInitial 首次规划；多个上报可能同时满足阈值，持锁后再次确认区服尚无计划
+func planInitial(zone string) {
+	unlock := lockZonePlan(zone)
+	if _, ok := planVersion(zone); ok {
+		unlock()
+		return
+	}
+	replanZoneLocked(zone, "initial")
+	unlock()
+	eq.PlanAndDispatch(zone)
+}
+
+// replanZone 立即重新规划并推送一个区服的副本分配，同时触发装备分配与交换事务；reason 记入规划历史
+func replanZone(zone, reason string) {
+	unlock := lockZonePlan(zone)
+	replanZoneLocked(zone, reason)
+	unlock()
+	eq.PlanAndDispatch(zone)
+}
+
+// replanZoneLocked 重新规划并推送副本分配；调用方需持有该区服的规划锁
+func replanZoneLocked(zone, reason string) {
+	planTime := time.Now()
+	snap := roles.Instance().SnapshotZone(zone)
+	prev, _, _, _ := getPlanStateSnapshot(zone)
+	res := alloc.PlanSnapshot(zone, snap, prev)
+	updatePlanState(zone, reason, res, planTime, snap.Version)
+	if as := res.Assignments; len(as) > 0 {
+		dispatchAssignments(snap, as)
+		markPlanSent(zone, time.Now())
+	}
+}
+
+// mergeZones 合区：角色、任务队列、交换事务、分配记录依次迁移到目标区服，随后立即重新规划
+func mergeZones(target string, sources []string, mergeState string) (roles.MergeResult, error) {
+	res, err := roles.Instance().MergeZones(target, sources, mergeState)
+	if err != nil {
+		return res, err
+	}
+	tasks.Instance().MergeZones(res.Zone, res.Sources)
+	if err := eq.MergeZones(res.Zone, res.Sources); err != nil {
+		res.Errors = append(res.Errors, "exchanges: "+err.Error())
+	}
+	alloc.MergeOverrides(res.Zone, res.Sources)
+	for _, s := range res.Sources {
+		unlock := lockZonePlan(s)
+		clearPlanState(s)
+		unlock()
+	}
+	lastAssign.mu.Lock()
+	for _, s := range res.Sources {
+		prefix := s + "|"
+		for k, v := range lastAssign.m {
+			if strings.HasPrefix(k, prefix) {
+				nk := res.Zone + "|" + strings.TrimPrefix(k, prefix)
+				if _, ok := lastAssign.m[nk]; !ok {
+					lastAssign.m[nk] = v
+				}
+				delete(lastAssign.m, k)
+			}
+		}
+	}
+	lastAssign.mu.Unlock()
+	logger.MapAlloc().Printf("zone merge zone=%s sources=%v merge=%s; replanning", res.Zone, res.Sources, mergeState)
+	unlock := lockZonePlan(res.Zone)
+	clearPlanState(res.Zone)
+	replanZoneLocked(res.Zone, "merge")
+	unlock()
+	eq.PlanAndDispatch(res.Zone)
+	return res, nil
+}
+
+// called on disconnect to cleanup roles owned by client
+func onClientDisconnected(clientID string) { roles.Instance().RemoveClient(clientID) }
+
+// 标注ACK：将该client_id关联的所有角色分配视为已被确认（仅做日志记录，周期间仍会按策略推送）
+func onAckReceived(clientID string) {
+	zones := roles.Instance().ListZones()
+	for _, z := range zones {
+		snap := roles.Instance().SnapshotZone(z)
+		for role, cid := range snap.ClientByRole {
+			if cid == clientID {
+				logger.MapAlloc().Printf("ack confirmed zone=%s role=%s client_id=%s", z, role, clientID)
+			}
+		}
+	}
+}
+
+// 首次规划的人数阈值取自规划规则中各合区状态的满员人数
+func neededByMerge(ms string) int { return alloc.NeededByMerge(ms) }
+
+// ReplanAll 对所有已有计划的区服立即重新规划（规则热加载后调用）
+func ReplanAll(reason string) {
+	for _, z := range roles.Instance().ListZones() {
+		if _, ok := planVersion(z); !ok {
+			continue
+		}
+		logger.MapAlloc().Printf("zone=%s replan triggered by %s", z, reason)
+		replanZone(z, reason)
+	}
+}
+
+// fillVacancies 角色离开后递补其名额，只推送目标或队伍变化的角色；不重置 3 小时重规划计时
+func fillVacancies(zone string) {
+	unlock := lockZonePlan(zone)
+	defer unlock()
+	prev, lastPlan, _, ok := getPlanStateSnapshot(zone)
+	if !ok {
+		return
+	}
+	snap := roles.Instance().SnapshotZone(zone)
+	res, filled := alloc.FillVacancies(zone, snap, prev)
+	if !filled {
+		return
+	}
+	updatePlanState(zone, "vacancy", res, lastPlan, snap.Version)
+	prevOf := make(map[string]alloc.Assignment, len(prev))
+	for _, a := range prev {
+		prevOf[a.RoleName] = a
+	}
+	var changed []alloc.Assignment
+	for _, a := range res.Assignments {
+		if pa, ok := prevOf[a.RoleName]; !ok || pa.Target != a.Target || pa.Party.String() != a.Party.String() {
+			changed = append(changed, a)
+		}
+	}
+	if len(changed) > 0 {
+		dispatchAssignments(snap, changed)
+	}
+}
+
+// reassignNonCompliant 连续未按目标执行的角色在一段时间内排除出该地图并重新规划
+func reassignNonCompliant(now time.Time) {
+	for zone, recs := range compliance.Instance().Tick(now) {
+		for _, rec := range recs {
+			_, err := alloc.SetOverride(alloc.Override{Zone: zone, Kind: alloc.OverrideExclude, Role: rec.Role, Map: rec.Map,
+				ExpiresAt: now.Add(compliance.Instance().ReassignTTL()), Note: "compliance: " + rec.Status})
+			if err != nil {
+				logger.MapAlloc().Printf("zone=%s compliance reassign role=%s failed: %v", zone, rec.Role, err)
+			}
+		}
+		if _, ok := planVersion(zone); ok {
+			logger.MapAlloc().Printf("zone=%s replan triggered by compliance (%d roles)", zone, len(recs))
+			replanZone(zone, "compliance")
+		}
+	}
+}
+
+// Global accessors
+func HubInstance() *Hub { return defaultHub }
+
+// Look up a connected client by id (nil if not connected)
+func clientByID(clientID string) *Client {
+	h := HubInstance()
+	if h == nil {
+		return nil
+	}
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	return h.clients[clientID]
+}
+
+// Send JSON to a client by id (non-blocking)
+func SendJSON(clientID string, v any) {
+	c := clientByID(clientID)
+	if c == nil {
+		return
+	}
+	b, _ := json.Marshal(v)
+	select {
+	case c.Send <- b:
+	default:
+	}
+}
+
+// 仅在分配目标变化时记录： 角色名 原先副本地图----->规划副本地图
+func logMapAssignIfChanged(snap *roles.ZoneState, role string, target alloc.MapTarget) {
+	// zone 取自该角色所在快照（ClientByRole 的 key 未显式包含 zone，这里取一个快照字段不足以得到 zone 名，
+	// 因此从角色对象读取充值区服更稳妥）
+	zone := ""
+	if rr, ok := snap.Roles[role]; ok {
+		zone = rr.Zone
+	}
+	key := zone + "|" + role
+	lastAssign.mu.Lock()
+	prev, ok := lastAssign.m[key]
+	same := ok && prev.Map == target.Map && prev.Floor == target.Floor
+	if !same {
+		prevStr := prev.Map
+		if prev.Floor > 0 {
+			prevStr = prevStr + formatFloor(prev.Floor)
+		}
+		nowStr := target.Map
+		if target.Floor > 0 {
+			nowStr = nowStr + formatFloor(target.Floor)
+		}
+		logger.MapAlloc().Printf("role=%s prev=%s -> plan=%s", role, nonEmpty(prevStr, "(无)"), nowStr)
+		lastAssign.m[key] = target
+	}
+	lastAssign.mu.Unlock()
+}
+
+func formatFloor(f int) string { return "-" + itoa(f) }
+func dispatchAssignments(snap *roles.ZoneState, assignments []alloc.Assignment) {
+	for _, a := range assignments {
+		cid := snap.ClientByRole[a.RoleName]
+		if cid == "" {
+			continue
+		}
+		c := clientByID(cid)
+		msg := MapAssignment{RoleName: a.RoleName, ClientID: cid}
+		msg.Data.Map = a.Target.Map
+		msg.Data.Party = partyRoster(a.Party)
+		if ri, ok := snap.Roles[a.RoleName]; ok && a.Target.Floor > 0 {
+			if c != nil && c.floorSupported(ri.Class) {
+				msg.Data.Floor = a.Target.Floor
+			}
+		}
+		logMapAssignIfChanged(snap, a.RoleName, a.Target)
+		if ri, ok := snap.Roles[a.RoleName]; ok {
+			compliance.Instance().Assign(ri.Zone, ri, a.Target.Map, a.Target.Floor, time.Now())
+		}
+		if c == nil {
+			continue
+		}
+		if changed := c.markSent(a.RoleName, alloc.MapTarget{Map: msg.Data.Map, Floor: msg.Data.Floor}, a.Party); !changed && c.ChangesOnly.Load() {
+			continue
+		}
+		SendJSON(cid, msg)
+	}
+}
+
+func partyRoster(pt *alloc.Party) *PartyRoster {
+	if pt == nil {
+		return nil
+	}
+	return &PartyRoster{ID: pt.ID, Leader: pt.Leader, Members: pt.Members}
+}
+
+func mergeStateFromSnapshot(zs *roles.ZoneState) string {
+	for _, r := range zs.Roles {
+		if r.MergeState != "" {
+			return r.MergeState
+		}
+	}
+	return "未合区"
+}
+
+func itoa(v int) string { return fmt.Sprintf("%d", v) }
+func nonEmpty(s, alt string) string {
+	if s == "" {
+		return alt
+	}
+	return s
+}
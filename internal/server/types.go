@@ -1,71 +1,106 @@
-package server
-
-import (
-	"time"
-	t "wgserver/internal/types"
-)
-
-// Incoming message generic envelope
-// We accept flexible keys; using map[string]any for raw, then try structured.
-
-// MsgType moved to internal/types
-
-type DailyTaskMessage = t.DailyTaskMessage
-
-type Heartbeat struct {
-	Type     string `json:"type"`
-	ClientID string `json:"client_id"`
-}
-
-type HeartbeatResp struct {
-	Type     string `json:"type"`
-	ClientID string `json:"client_id"`
-	Status   string `json:"status"`
-}
-
-type ConnectionAck struct {
-	Code     int    `json:"code"`
-	Message  string `json:"Message"`
-	Type     string `json:"type"`
-	ClientID string `json:"client_id"`
-}
-
-type AckReceived struct {
-	ClientID string `json:"client_id"`
-	Status   string `json:"status"`
-}
-
-// Role attribute payload (primary fields only; many optional)
-// Field names are Chinese; use struct tags accordingly.
-
-type RoleAttributes = t.RoleAttributes
-type EquipItem = t.EquipItem
-type Item = t.Item
-
-// Map assignment push
-
-type MapAssignment struct {
-	RoleName string `json:"角色名"`
-	Data     struct {
-		Map   string `json:"地图"`
-		Floor int    `json:"层数,omitempty"`
-	} `json:"data"`
-	ClientID string `json:"client_id"`
-}
-
-// Exchange transaction tracking (server-side)
-
-type ExchangeState struct {
-	Owner struct {
-		RoleName string    `json:"角色名"`
-		Status   string    `json:"状态"`
-		When     time.Time `json:"确认时间"`
-	} `json:"拥有者"`
-	Receiver struct {
-		RoleName string    `json:"角色名"`
-		Status   string    `json:"状态"`
-		When     time.Time `json:"确认时间"`
-	} `json:"接收者"`
-	Status string    `json:"总体状态"`
-	Create time.Time `json:"创建时间"`
-}
+package server
+
+import (
+	"time"
+	t "wgserver/internal/types"
+)
+
+// Incoming message generic envelope
+// We accept flexible keys; using map[string]any for raw, then try structured.
+
+// MsgType moved to internal/types
+
+type DailyTaskMessage = t.DailyTaskMessage
+
+type Heartbeat struct {
+	Type     string `json:"type"`
+	ClientID string `json:"client_id"`
+}
+
+type HeartbeatResp struct {
+	Type     string `json:"type"`
+	ClientID string `json:"client_id"`
+	Status   string `json:"status"`
+}
+
+type ConnectionAck struct {
+	Code     int    `json:"code"`
+	Message  string `json:"Message"`
+	Type     string `json:"type"`
+	ClientID string `json:"client_id"`
+}
+
+type AckReceived struct {
+	ClientID string `json:"client_id"`
+	Status   string `json:"status"`
+}
+
+// Role attribute payload (primary fields only; many optional)
+// Field names are Chinese; use struct tags accordingly.
+
+type RoleAttributes = t.RoleAttributes
+type EquipItem = t.EquipItem
+type Item = t.Item
+
+// Map assignment push
+
+type MapAssignment struct {
+	RoleName string `json:"角色名"`
+	Data     struct {
+		Map   string       `json:"地图"`
+		Floor int          `json:"层数,omitempty"`
+		Party *PartyRoster `json:"队伍,omitempty"`
+	} `json:"data"`
+	ClientID string `json:"client_id"`
+}
+
+// 组队地图的队伍名单，成员中队长在前
+type PartyRoster struct {
+	ID      string   `json:"队伍编号"`
+	Leader  string   `json:"队长"`
+	Members []string `json:"成员"`
+}
+
+// Assignment query: 角色名为空时查询该连接上报的全部角色；充值区服为空时在所有区服中查找
+
+type QueryAssignment struct {
+	MsgType    string `json:"消息类型"`
+	RoleName   string `json:"角色名"`
+	Zone       string `json:"充值区服"`
+	MergedZone string `json:"合区区服"` // 旧版客户端使用的字段名
+	ClientID   string `json:"client_id"`
+}
+
+// 查询结果，状态：已分配 / 未分配（已规划但本轮无目标）/ 未规划（区服尚未首次规划）/ 未知角色 /
+// 无角色（未指定角色名且该连接在所查区服没有角色）/ 格式错误（查询消息无法解析）
+type AssignmentReply struct {
+	MsgType  string `json:"消息类型"`
+	RoleName string `json:"角色名"`
+	Zone     string `json:"充值区服,omitempty"`
+	Status   string `json:"状态"`
+	Data     struct {
+		Map         string       `json:"地图,omitempty"`
+		Floor       int          `json:"层数,omitempty"`
+		Party       *PartyRoster `json:"队伍,omitempty"`
+		PlanVersion int          `json:"计划版本,omitempty"`
+		NextPlan    string       `json:"下次规划时间,omitempty"` // UTC+8 "2006-01-02 15:04:05"
+	} `json:"data"`
+	ClientID string `json:"client_id"`
+}
+
+// Exchange transaction tracking (server-side)
+
+type ExchangeState struct {
+	Owner struct {
+		RoleName string    `json:"角色名"`
+		Status   string    `json:"状态"`
+		When     time.Time `json:"确认时间"`
+	} `json:"拥有者"`
+	Receiver struct {
+		RoleName string    `json:"角色名"`
+		Status   string    `json:"状态"`
+		When     time.Time `json:"确认时间"`
+	} `json:"接收者"`
+	Status string    `json:"总体状态"`
+	Create time.Time `json:"创建时间"`
+}
@@ -1,608 +1,533 @@
-package alloc
-
-import (
-	"sort"
-	"strings"
-
-	"wgserver/internal/logger"
-	eq "wgserver/internal/services/equipment"
-	"wgserver/internal/services/roles"
-)
-
-type MapTarget struct {
-	Map   string
-	Floor int
-}
-
-type Assignment struct {
-	RoleName string
-	Target   MapTarget
-}
-
-// Difficulty order low->high; we will create reversed for high-first needs
-var mapsOrder = []string{"将军坟", "将军坟东", "机关洞", "五蛇殿", "玄冰古道", "远古机关洞", "远古蛇殿", "通天塔", "禁地魔穴", "远古逆魔", "地下魔域"}
-
-// Minimal counts per merge state. If insufficient roles, apply fallback as spec.
-
-func requiredCounts(merge string) (total int, mageFixed []MapTarget, otherPlan map[string]int) {
-	switch merge {
-	case "未合区":
-		// 2 mages fixed to 机关洞5/6层
-		mageFixed = []MapTarget{{"机关洞", 5}, {"机关洞", 6}}
-		// base other 10 as starting state (case 1) - dynamic adjustment later by eq
-		otherPlan = map[string]int{"将军坟": 1, "将军坟东": 1, "机关洞": 3, "五蛇殿": 5}
-		total = 12
-	case "一合", "一合区", "第一次合区":
-		mageFixed = []MapTarget{{"机关洞", 5}, {"机关洞", 6}, {"远古机关洞", 2}, {"远古机关洞", 3}}
-		otherPlan = map[string]int{} // dynamic within function 2 rules
-		total = 24
-	case "二合", "三合", "四合", "五合", "六合":
-		mageFixed = []MapTarget{{"机关洞", 5}, {"机关洞", 6}, {"玄冰古道", 2}, {"玄冰古道", 3}, {"远古机关洞", 2}, {"远古机关洞", 3}, {"远古逆魔", 1}, {"远古逆魔", 2}}
-		otherPlan = map[string]int{"将军坟": 2, "机关洞": 2, "五蛇殿": 2, "远古机关洞": 2, "远古蛇殿": 3, "通天塔": 6, "禁地魔穴": 6, "远古逆魔": 9, "地下魔域": 8}
-		total = 48
-	case "七合", "七合以后":
-		mageFixed = []MapTarget{{"地下魔域", 1}, {"地下魔域", 2}}
-		otherPlan = map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 1, "远古机关洞": 1, "远古蛇殿": 1, "通天塔": 4, "禁地魔穴": 5, "远古逆魔": 6, "地下魔域": 6}
-		total = 28
-	default:
-		// default treat as 未合区
-		mageFixed = []MapTarget{{"机关洞", 5}, {"机关洞", 6}}
-		otherPlan = map[string]int{"将军坟": 1, "将军坟东": 1, "机关洞": 3, "五蛇殿": 5}
-		total = 12
-	}
-	return
-}
-
-func isMage(class string) bool { return class == "法师" }
-
-func luckyHigh(lucky int) bool { return lucky >= 9 }
-
-func strengthScore(r *roles.RoleInfo) int {
-	base := r.Magic
-	if luckyHigh(r.Lucky) {
-		base += 10000
-	}
-	if r.Level >= 60 {
-		base += 5000
-	}
-	return base
-}
-
-// ---------- 天尊或以上(>=A) 4主体判定（基于当前已穿戴装备信息） ----------
-func hasATierFourPiece(r *roles.RoleInfo) bool {
-	// 反向索引：装备名 -> 套装名
-	// 遍历已穿戴：按部位去重（戒指/手镯同名不可算两件）
-	// 判定某套装不同部位数量>=4 且该套强度>=A(>=70)
-	// 构建逆索引一次（简单起见每次构建）
-	itemToSet := map[string]string{}
-	for setName, items := range eq.EquipmentSets {
-		for name := range items {
-			itemToSet[name] = setName
-		}
-	}
-	// 同套不同部位计数
-	type uniq struct {
-		pos  string
-		name string
-	}
-	setPos := map[string]map[string]struct{}{}
-	for _, e := range r.Equipments {
-		setName, ok := itemToSet[e.Name]
-		if !ok {
-			continue
-		}
-		rank := eq.EquipmentRank[setName]
-		if rank < 70 {
-			continue
-		}
-		pos := normalizePos(e.Slot)
-		if pos == "手镯" {
-			pos = "手镯"
-		} // 两个手镯视为同类位置，不重复计数
-		if pos == "戒指" {
-			pos = "戒指"
-		}
-		if setPos[setName] == nil {
-			setPos[setName] = map[string]struct{}{}
-		}
-		// 去重：相同位置只计一次
-		setPos[setName][pos] = struct{}{}
-	}
-	for setName, posSet := range setPos {
-		if len(posSet) >= 4 && eq.EquipmentRank[setName] >= 70 {
-			return true
-		}
-	}
-	return false
-}
-
-func normalizePos(p string) string {
-	if p == "手镯1" || p == "手镯2" {
-		return "手镯"
-	}
-	if p == "戒指1" || p == "戒指2" {
-		return "戒指"
-	}
-	switch {
-	case strings.Contains(p, "头"):
-		return "头"
-	case strings.Contains(p, "项链"):
-		return "项链"
-	case strings.Contains(p, "腰带"):
-		return "腰带"
-	case strings.Contains(p, "靴") || strings.Contains(p, "鞋"):
-		return "鞋子"
-	case strings.Contains(p, "手镯"):
-		return "手镯"
-	case strings.Contains(p, "戒指"):
-		return "戒指"
-	default:
-		return p
-	}
-}
-
-// Evaluate and push assignments for a zone
-func Plan(zone string) []Assignment {
-	m := roles.Instance()
-	zs := m.SnapshotZone(zone)
-	if len(zs.Roles) == 0 {
-		return nil
-	}
-
-	// Group by mage/others
-	var mages, others []*roles.RoleInfo
-	for _, r := range zs.Roles {
-		if isMage(r.Class) {
-			mages = append(mages, r)
-		} else {
-			others = append(others, r)
-		}
-	}
-
-	// Sort by strength desc for others and mages
-	sort.Slice(others, func(i, j int) bool { return strengthScore(others[i]) > strengthScore(others[j]) })
-	sort.Slice(mages, func(i, j int) bool { return strengthScore(mages[i]) > strengthScore(mages[j]) })
-
-	// Determine required counts
-	totalRequired, mageFixed, otherPlan := requiredCounts(zsAnyMerge(zs))
-	insufficient := len(zs.Roles) < totalRequired
-
-	as := []Assignment{}
-	// Assign mages: 若人数不足，按高难顺序分配；否则按固定表
-	mageTargets := mageFixed
-	if insufficient {
-		mageTargets = mageInsufficientOrder(zsAnyMerge(zs))
-		if len(mageTargets) == 0 {
-			mageTargets = mageFixed
-		}
-	}
-	assignMageN := min(len(mages), len(mageTargets))
-	for i := 0; i < assignMageN; i++ {
-		as = append(as, Assignment{RoleName: mages[i].RoleName, Target: mageTargets[i]})
-	}
-	// 多余法师并入其他职业流程
-	if len(mages) > assignMageN {
-		others = append(mages[assignMageN:], others...)
-	}
-
-	// 人数不足：统一执行“覆盖+填充”的通用逻辑；否则按各合区完整规则
-	if insufficient {
-		as = append(as, assignOthers_Insufficient(others, otherPlan, zsAnyMerge(zs))...)
-	} else {
-		// Apply specific rules for 未合区与一合
-		if strings.Contains(zsAnyMerge(zs), "未合") {
-			as = append(as, assignOthers_Unmerged(others, otherPlan)...)
-		} else if strings.Contains(zsAnyMerge(zs), "一合") || strings.Contains(zsAnyMerge(zs), "第一次合区") {
-			as = append(as, assignOthers_Merge1(others)...)
-		} else {
-			as = append(as, assignOthers_FixedPlan(others, otherPlan)...)
-		}
-	}
-	logger.MapAlloc().Printf("zone=%s plan assignments=%d", zone, len(as))
-	return as
-}
-
-func zsAnyMerge(zs *roles.ZoneState) string {
-	for _, r := range zs.Roles {
-		return r.MergeState
-	}
-	return "未合区"
-}
-
-// 不足人数时：其他职业“覆盖+填充”
-func assignOthers_Insufficient(others []*roles.RoleInfo, plan map[string]int, merge string) []Assignment {
-	if len(others) == 0 {
-		return nil
-	}
-	// Lucky9优先，其次强度
-	sort.SliceStable(others, func(i, j int) bool {
-		li, lj := luckyHigh(others[i].Lucky), luckyHigh(others[j].Lucky)
-		if li != lj {
-			return li
-		}
-		return strengthScore(others[i]) > strengthScore(others[j])
-	})
-	// 地图优先级（高->低）
-	order := otherMapsOrderHigh(merge)
-	// 若提供了计划数量，仅保留在计划中的图；否则全部保留
-	filtered := []string{}
-	if len(plan) > 0 {
-		for _, m := range order {
-			if _, ok := plan[m]; ok {
-				filtered = append(filtered, m)
-			}
-		}
-	} else {
-		filtered = order
-	}
-	// 拷贝一份可写计数；plan 为空时用一个大数代表“充足”
-	counts := map[string]int{}
-	if len(plan) > 0 {
-		for k, v := range plan {
-			counts[k] = v
-		}
-	} else {
-		for _, m := range filtered {
-			counts[m] = 1 << 30
-		}
-	}
-	used := map[string]bool{}
-	res := []Assignment{}
-	// Step1: 覆盖——每张规划图至少放1人（若有可进入者）
-	for _, m := range filtered {
-		if counts[m] <= 0 {
-			continue
-		}
-		// 选择最优可进入的候选
-		for _, r := range others {
-			if used[r.RoleName] {
-				continue
-			}
-			if (m == "远古逆魔" || m == "地下魔域") && r.Level < 60 {
-				continue
-			}
-			res = append(res, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: m, Floor: 1}})
-			used[r.RoleName] = true
-			counts[m]--
-			break
-		}
-	}
-	// Step2: 填充——从最高难开始，直至该图数量满足，再到下一图
-	for _, m := range filtered {
-		for counts[m] > 0 {
-			picked := false
-			for _, r := range others {
-				if used[r.RoleName] {
-					continue
-				}
-				if (m == "远古逆魔" || m == "地下魔域") && r.Level < 60 {
-					continue
-				}
-				res = append(res, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: m, Floor: 1}})
-				used[r.RoleName] = true
-				counts[m]--
-				picked = true
-				break
-			}
-			if !picked {
-				break
-			} // 没有更多可用人选
-		}
-	}
-	// 若仍有未用完的角色（无配额限制或所有配额未填完但都不适配），按次高难溢出
-	for _, r := range others {
-		if used[r.RoleName] {
-			continue
-		}
-		// 找一个能进的最高难地图
-		for _, m := range filtered {
-			if (m == "远古逆魔" || m == "地下魔域") && r.Level < 60 {
-				continue
-			}
-			res = append(res, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: m, Floor: 1}})
-			used[r.RoleName] = true
-			break
-		}
-	}
-	return res
-}
-
-func otherMapsOrderHigh(merge string) []string {
-	switch {
-	case strings.Contains(merge, "七合"):
-		return []string{"地下魔域", "远古逆魔", "禁地魔穴", "通天塔", "远古蛇殿", "远古机关洞", "五蛇殿", "机关洞", "将军坟"}
-	case strings.Contains(merge, "二合") || strings.Contains(merge, "三合") || strings.Contains(merge, "四合") || strings.Contains(merge, "五合") || strings.Contains(merge, "六合"):
-		return []string{"地下魔域", "远古逆魔", "禁地魔穴", "通天塔", "远古蛇殿", "远古机关洞", "玄冰古道", "五蛇殿", "机关洞", "将军坟"}
-	case strings.Contains(merge, "一合"):
-		return []string{"通天塔", "禁地魔穴", "远古蛇殿", "五蛇殿", "机关洞", "将军坟"}
-	default: // 未合区
-		return []string{"五蛇殿", "机关洞", "将军坟东", "将军坟"}
-	}
-}
-
-// 不足人数时：法师目标按高难度排序
-func mageInsufficientOrder(merge string) []MapTarget {
-	switch {
-	case strings.Contains(merge, "七合"):
-		return []MapTarget{{Map: "地下魔域", Floor: 2}, {Map: "地下魔域", Floor: 1}}
-	case strings.Contains(merge, "二合") || strings.Contains(merge, "三合") || strings.Contains(merge, "四合") || strings.Contains(merge, "五合") || strings.Contains(merge, "六合"):
-		return []MapTarget{{Map: "远古逆魔", Floor: 2}, {Map: "远古逆魔", Floor: 1}, {Map: "远古机关洞", Floor: 3}, {Map: "远古机关洞", Floor: 2}, {Map: "玄冰古道", Floor: 3}, {Map: "玄冰古道", Floor: 2}, {Map: "机关洞", Floor: 6}, {Map: "机关洞", Floor: 5}}
-	case strings.Contains(merge, "一合"):
-		return []MapTarget{{Map: "远古机关洞", Floor: 3}, {Map: "远古机关洞", Floor: 2}, {Map: "机关洞", Floor: 6}, {Map: "机关洞", Floor: 5}}
-	default: // 未合区
-		return []MapTarget{{Map: "机关洞", Floor: 6}, {Map: "机关洞", Floor: 5}}
-	}
-}
-
-// 未合区其他职业分配：完整实现 1)~5) 以及 6) 技能150细则
-func assignOthers_Unmerged(others []*roles.RoleInfo, basePlan map[string]int) []Assignment {
-	if len(others) == 0 {
-		return nil
-	}
-	// 标记具备 A 级及以上四主体（天尊或更高）的角色
-	var eligible, rest []*roles.RoleInfo
-	for _, r := range others {
-		if hasATierFourPiece(r) {
-			eligible = append(eligible, r)
-		} else {
-			rest = append(rest, r)
-		}
-	}
-	sort.SliceStable(eligible, func(i, j int) bool { return strengthScore(eligible[i]) > strengthScore(eligible[j]) })
-	sort.SliceStable(rest, func(i, j int) bool { return strengthScore(rest[i]) > strengthScore(rest[j]) })
-
-	// 统计技能150人数（仅在 eligible 条件满足 4 个后才应用第6步）
-	skill150 := 0
-	for _, r := range others {
-		if r.Skill >= 150 {
-			skill150++
-		}
-	}
-
-	plan := map[string]int{}
-	// 先处理 1)~5)
-	switch x := min(len(eligible), 4); x {
-	case 0:
-		for k, v := range basePlan {
-			plan[k] = v
-		}
-	case 1:
-		plan = map[string]int{"将军坟": 1, "将军坟东": 1, "机关洞": 3, "五蛇殿": 4, "通天塔": 1}
-	case 2:
-		plan = map[string]int{"将军坟": 1, "将军坟东": 1, "机关洞": 2, "五蛇殿": 4, "通天塔": 2}
-	case 3:
-		plan = map[string]int{"将军坟": 1, "将军坟东": 1, "机关洞": 2, "五蛇殿": 3, "通天塔": 3}
-	case 4:
-		plan = map[string]int{"将军坟": 1, "机关洞": 2, "五蛇殿": 3, "通天塔": 4}
-	}
-	// 第6步：当达到4个 eligible 后，按已达成的 skill150 数量动态调整
-	if len(eligible) >= 4 {
-		switch {
-		case skill150 >= 1 && skill150 < 2:
-			plan = map[string]int{"将军坟": 1, "机关洞": 2, "五蛇殿": 2, "通天塔": 4, "禁地魔穴": 1}
-		case skill150 == 2:
-			plan = map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 2, "通天塔": 4, "禁地魔穴": 2}
-		case skill150 == 3:
-			plan = map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 1, "通天塔": 4, "禁地魔穴": 3}
-		case skill150 == 4:
-			// 第4个技能150进入通天塔（但TT最多4）
-			plan = map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 1, "通天塔": 4, "禁地魔穴": 3}
-		case skill150 == 5 || skill150 == 6 || skill150 == 7:
-			plan = map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 1, "通天塔": 4, "禁地魔穴": 3}
-		case skill150 >= 8:
-			plan = map[string]int{"机关洞": 1, "五蛇殿": 1, "通天塔": 4, "禁地魔穴": 3, "远古蛇殿": 1}
-		}
-	}
-	// 分配：优先将通天塔数量分给 eligible（幸运9和高道术优先）
-	sort.SliceStable(others, func(i, j int) bool {
-		li, lj := luckyHigh(others[i].Lucky), luckyHigh(others[j].Lucky)
-		if li != lj {
-			return li
-		}
-		return strengthScore(others[i]) > strengthScore(others[j])
-	})
-	used := map[string]bool{}
-	result := []Assignment{}
-	// 先通天塔
-	ttNeed := plan["通天塔"]
-	if ttNeed > 0 {
-		for _, r := range eligible {
-			if ttNeed == 0 {
-				break
-			}
-			if used[r.RoleName] {
-				continue
-			}
-			result = append(result, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: "通天塔", Floor: 1}})
-			used[r.RoleName] = true
-			ttNeed--
-		}
-		plan["通天塔"] = ttNeed
-	}
-	// 再禁地魔穴（若有）
-	jindi := plan["禁地魔穴"]
-	for _, r := range others {
-		if jindi == 0 {
-			break
-		}
-		if used[r.RoleName] {
-			continue
-		}
-		result = append(result, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: "禁地魔穴", Floor: 1}})
-		used[r.RoleName] = true
-		jindi--
-	}
-	plan["禁地魔穴"] = jindi
-	// 远古蛇殿（若有）
-	fgsd := plan["远古蛇殿"]
-	for _, r := range others {
-		if fgsd == 0 {
-			break
-		}
-		if used[r.RoleName] {
-			continue
-		}
-		result = append(result, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: "远古蛇殿", Floor: 1}})
-		used[r.RoleName] = true
-		fgsd--
-	}
-	plan["远古蛇殿"] = fgsd
-	// 其余地图按难度从高到低/幸运9优先填充
-	order := []string{"五蛇殿", "机关洞", "将军坟东", "将军坟"}
-	for _, mname := range order {
-		need := plan[mname]
-		for _, r := range others {
-			if need == 0 {
-				break
-			}
-			if used[r.RoleName] {
-				continue
-			}
-			result = append(result, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: mname, Floor: 1}})
-			used[r.RoleName] = true
-			need--
-		}
-		plan[mname] = need
-	}
-	// 如果仍有未分配的通天塔名额（eligible 不够），用剩余强者填充
-	if plan["通天塔"] > 0 {
-		need := plan["通天塔"]
-		for _, r := range others {
-			if need == 0 {
-				break
-			}
-			if used[r.RoleName] {
-				continue
-			}
-			result = append(result, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: "通天塔", Floor: 1}})
-			used[r.RoleName] = true
-			need--
-		}
-		plan["通天塔"] = need
-	}
-	// 若还有未分配（理论上不会），溢出到五蛇殿
-	for _, r := range others {
-		if used[r.RoleName] {
-			continue
-		}
-		result = append(result, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: "五蛇殿", Floor: 1}})
-		used[r.RoleName] = true
-	}
-	return result
-}
-
-// 一合：按规则将最低强度5人分配：机关洞1、五蛇殿2、远古蛇殿2；其余15人动态：通天塔/禁地魔穴与60级后的远古逆魔、地下魔域
-func assignOthers_Merge1(others []*roles.RoleInfo) []Assignment {
-	assign := []Assignment{}
-	if len(others) == 0 {
-		return assign
-	}
-	low := min(5, len(others))
-	// lowest 5
-	last := len(others)
-	lo := others[last-low:]
-	base := map[string]int{"机关洞": 1, "五蛇殿": 2, "远古蛇殿": 2}
-	assign = append(assign, distributeByNeed(lo, base)...)
-	// remaining
-	rest := others[:last-low]
-	// initial 8 TT, 7 禁地
-	counts := map[string]int{"通天塔": 8, "禁地魔穴": 7}
-	// count of >=60
-	var sixty []*roles.RoleInfo
-	for _, r := range rest {
-		if r.Level >= 60 {
-			sixty = append(sixty, r)
-		}
-	}
-	sort.Slice(sixty, func(i, j int) bool { return strengthScore(sixty[i]) > strengthScore(sixty[j]) })
-	n := len(sixty)
-	switch {
-	case n >= 7:
-		assign = append(assign, topTo(sixty, 4, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 3, 4, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 4, "禁地魔穴": 4}
-	case n == 6:
-		assign = append(assign, topTo(sixty, 3, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 3, 3, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 5, "禁地魔穴": 4}
-	case n == 5:
-		assign = append(assign, topTo(sixty, 2, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 3, 2, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 5, "禁地魔穴": 5}
-	case n == 4:
-		assign = append(assign, topTo(sixty, 1, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 3, 1, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 6, "禁地魔穴": 5}
-	case n == 3:
-		assign = append(assign, topTo(sixty, 0, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 3, 0, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 6, "禁地魔穴": 6}
-	case n == 2:
-		assign = append(assign, topTo(sixty, 0, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 2, 0, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 7, "禁地魔穴": 6}
-	case n == 1:
-		assign = append(assign, topTo(sixty, 0, "地下魔域")...)
-		assign = append(assign, nextTo(sixty, 1, 0, "远古逆魔")...)
-		counts = map[string]int{"通天塔": 7, "禁地魔穴": 7}
-	default:
-		// no 60
-	}
-	assign = append(assign, distributeByNeed(rest, counts)...)
-	return assign
-}
-
-// 二-六合与七合以后：直接按固定人数计划，优先幸运9与高道术
-func assignOthers_FixedPlan(others []*roles.RoleInfo, plan map[string]int) []Assignment {
-	return distributeByNeed(others, plan)
-}
-
-// 通用分配：按幸运9优先/强度高优先，逐个角色为其选择能进入且仍有名额的最高难度地图
-func distributeByNeed(others []*roles.RoleInfo, plan map[string]int) []Assignment {
-	highToLow := []string{"地下魔域", "远古逆魔", "禁地魔穴", "通天塔", "远古蛇殿", "远古机关洞", "玄冰古道", "五蛇殿", "机关洞", "将军坟东", "将军坟"}
-	sort.SliceStable(others, func(i, j int) bool {
-		li, lj := luckyHigh(others[i].Lucky), luckyHigh(others[j].Lucky)
-		if li != lj {
-			return li
-		}
-		return strengthScore(others[i]) > strengthScore(others[j])
-	})
-	out := []Assignment{}
-	for _, r := range others {
-		for _, mp := range highToLow {
-			need := plan[mp]
-			if need <= 0 {
-				continue
-			}
-			// 60级门槛
-			if (mp == "远古逆魔" || mp == "地下魔域") && r.Level < 60 {
-				continue
-			}
-			out = append(out, Assignment{RoleName: r.RoleName, Target: MapTarget{Map: mp, Floor: 1}})
-			plan[mp] = need - 1
-			break
-		}
-	}
-	return out
-}
-
-func topTo(arr []*roles.RoleInfo, take int, m string) []Assignment {
-	assign := []Assignment{}
-	if take <= 0 {
-		return assign
-	}
-	for i := 0; i < take && i < len(arr); i++ {
-		assign = append(assign, Assignment{RoleName: arr[i].RoleName, Target: MapTarget{Map: m, Floor: 1}})
-	}
-	return assign
-}
-
-func nextTo(arr []*roles.RoleInfo, take int, offset int, m string) []Assignment {
-	assign := []Assignment{}
-	for i := offset; i < offset+take && i < len(arr); i++ {
-		assign = append(assign, Assignment{RoleName: arr[i].RoleName, Target: MapTarget{Map: m, Floor: 1}})
-	}
-	return assign
-}
-
-// no scheduler here to avoid import cycles; server package calls Plan and pushes
+package alloc
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+type MapTarget struct {
+	Map   string `json:"map"`
+	Floor int    `json:"floor"`
+}
+
+type Assignment struct {
+	RoleName string    `json:"role"`
+	Target   MapTarget `json:"target"`
+	Reason   *Reason   `json:"reason,omitempty"` // 分配说明，见 explain.go
+	Party    *Party    `json:"party,omitempty"`  // 组队地图的队伍，见 parties.go
+}
+
+// planner 单次规划的上下文：当前规则与本区服命中的合区规则（时段规则生效时为替换后的规则）
+type planner struct {
+	rules      *Rules
+	merge      *MergeRule
+	now        time.Time
+	schedule   string            // 生效的时段名，见 schedule.go
+	total      int               // 区服在线人数（含被 pin 的角色），用于判断人数是否不足
+	overrides  *zoneOverrides    // 人工干预，见 overrides.go
+	entryCache map[string]string // role|map -> 拒绝原因（空为可进入）
+	rejections []Rejection
+	scorer     Scorer
+	scoreCache map[string]ScoreBreakdown
+	fairWeight float64                             // 公平轮换权重，见 fairness.go
+	usage      map[string]map[string]time.Duration // 角色 -> 层级 -> 累计分配时长
+}
+
+func newPlanner(mergeState string, now time.Time) *planner {
+	rl := CurrentRules()
+	p := &planner{rules: rl, now: now}
+	var s *Schedule
+	p.merge, s = rl.MergeAt(mergeState, now)
+	if s != nil {
+		p.schedule = s.Name
+	}
+	return p
+}
+
+func isMage(class string) bool { return class == "法师" }
+
+func (p *planner) luckyHigh(lucky int) bool { return lucky >= p.rules.LuckHigh }
+
+// strengthScore 角色强度总分，见 scorer.go
+func (p *planner) strengthScore(r *roles.RoleInfo) int { return p.score(r).Total }
+
+// 按幸运达标优先、强度次之排序
+func (p *planner) sortByLuckThenStrength(list []*roles.RoleInfo) {
+	sort.SliceStable(list, func(i, j int) bool {
+		li, lj := p.luckyHigh(list[i].Lucky), p.luckyHigh(list[j].Lucky)
+		if li != lj {
+			return li
+		}
+		return p.stronger(list[i], list[j])
+	})
+}
+
+func copyQuotas(q map[string]int) map[string]int {
+	out := make(map[string]int, len(q))
+	for k, v := range q {
+		out[k] = v
+	}
+	return out
+}
+
+// ---------- 天尊或以上(>=A) 4主体判定（基于当前已穿戴装备信息） ----------
+func (p *planner) hasATierFourPiece(r *roles.RoleInfo) bool {
+	return p.bestFourPieceRank(r) >= p.rules.ATierRank
+}
+
+func normalizePos(p string) string {
+	if p == "手镯1" || p == "手镯2" {
+		return "手镯"
+	}
+	if p == "戒指1" || p == "戒指2" {
+		return "戒指"
+	}
+	switch {
+	case strings.Contains(p, "头"):
+		return "头"
+	case strings.Contains(p, "项链"):
+		return "项链"
+	case strings.Contains(p, "腰带"):
+		return "腰带"
+	case strings.Contains(p, "靴") || strings.Contains(p, "鞋"):
+		return "鞋子"
+	case strings.Contains(p, "手镯"):
+		return "手镯"
+	case strings.Contains(p, "戒指"):
+		return "戒指"
+	default:
+		return p
+	}
+}
+
+// Evaluate and push assignments for a zone
+func Plan(zone string) []Assignment {
+	return PlanSnapshot(zone, roles.Instance().SnapshotZone(zone), nil).Assignments
+}
+
+// PlanSnapshot 基于给定快照规划，调用方可用同一快照下发结果并记录其版本。
+// prev 为上一轮计划（可为空），强度相差不超过 StabilityGain 的角色尽量保留原目标。
+func PlanSnapshot(zone string, zs *roles.ZoneState, prev []Assignment) PlanResult {
+	if len(zs.Roles) == 0 {
+		return PlanResult{}
+	}
+	p := newPlanner(zsAnyMerge(zs), time.Now())
+	mode := p.rules.PlannerFor(zone)
+	as := p.run(zone, zs, mode)
+	p.spreadFloors(zs, as, prev)
+	// stabilize 会改写 as 中的目标，总收益与换图人数按实际下发的计划计算
+	kept := p.stabilize(zs, as, prev)
+	res := PlanResult{Assignments: as, Mode: mode, Schedule: p.schedule, Kept: kept}
+	res.Objective = p.objective(zs, as)
+	res.Moves = countMoves(as, prev)
+	p.formParties(zone, zs, as)
+	p.explain(zs, as)
+	logger.MapAlloc().Printf("zone=%s plan mode=%s assignments=%d moves=%d kept=%d version=%d rules=%d merge=%s schedule=%s",
+		zone, mode, len(as), res.Moves, res.Kept, zs.Version, p.rules.Version, p.merge.Name, p.schedule)
+	logExplanations(zone, as)
+	p.logUnassigned(zone, zs, as)
+	for _, name := range sortedKeys(p.overrides.pinReasons) {
+		logger.MapAlloc().Printf("zone=%s pin ignored role=%s reason=%s", zone, name, p.overrides.pinReasons[name])
+	}
+	if p.fairWeight > 0 {
+		logger.MapAlloc().Printf("zone=%s fairness weight=%.2f days=%d tracked=%d", zone, p.fairWeight, p.rules.Fairness.Days, len(p.usage))
+	}
+	checkPlan(zone, as, zs, nil)
+	return res
+}
+
+// run 先处理人工干预（pin 与排除），再按指定方式规划其余角色
+func (p *planner) run(zone string, zs *roles.ZoneState, mode string) []Assignment {
+	p.total = len(zs.Roles)
+	p.overrides = activeOverrides(zone, p.now)
+	pinned, rest := p.applyOverrides(zs)
+	var as []Assignment
+	if mode == PlannerOptimal {
+		as = p.planOptimal(rest)
+	} else {
+		p.fairnessFor(zone)
+		as = p.planRules(rest)
+	}
+	return append(pinned, as...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// splitByClass 按职业分组并按强度排序
+func (p *planner) splitByClass(zs *roles.ZoneState) (mages, others []*roles.RoleInfo) {
+	for _, r := range zs.Roles {
+		if isMage(r.Class) {
+			mages = append(mages, r)
+		} else {
+			others = append(others, r)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return p.stronger(others[i], others[j]) })
+	sort.Slice(mages, func(i, j int) bool { return p.stronger(mages[i], mages[j]) })
+	return mages, others
+}
+
+// mageTargets 法师固定层：人数不足时按高难顺序，否则按固定表
+func (p *planner) mageTargets(insufficient bool) ([]MapTarget, string) {
+	if insufficient && len(p.merge.MageInsufficient) > 0 {
+		return p.freeMageTargets(p.merge.MageInsufficient), BranchMageInsufficient
+	}
+	return p.freeMageTargets(p.merge.MageFixed), BranchMageFixed
+}
+
+// planRules 逐级贪心规则
+func (p *planner) planRules(zs *roles.ZoneState) []Assignment {
+	mages, others := p.splitByClass(zs)
+	insufficient := p.total < p.merge.Total
+
+	as := []Assignment{}
+	mageTargets, mageBranch := p.mageTargets(insufficient)
+	// 每个固定层按强度顺序取第一个可进入的法师
+	mageUsed := map[string]bool{}
+	for _, mt := range mageTargets {
+		for _, r := range mages {
+			if !mageUsed[r.RoleName] && p.canEnter(r, mt.Map) {
+				mageUsed[r.RoleName] = true
+				as = append(as, p.assignTo(r, mt, mageBranch, mages, mageUsed))
+				break
+			}
+		}
+	}
+	// 多余法师并入其他职业流程
+	var extra []*roles.RoleInfo
+	for _, r := range mages {
+		if !mageUsed[r.RoleName] {
+			extra = append(extra, r)
+		}
+	}
+	if len(extra) > 0 {
+		others = append(extra, others...)
+	}
+
+	// 人数不足：统一执行“覆盖+填充”的通用逻辑；否则按各合区完整规则
+	if insufficient {
+		as = append(as, p.assignOthers_Insufficient(others)...)
+	} else {
+		switch p.merge.Strategy {
+		case StrategyUnmerged:
+			as = append(as, p.assignOthers_Unmerged(others)...)
+		case StrategyMerge1:
+			as = append(as, p.assignOthers_Merge1(others)...)
+		default:
+			as = append(as, p.assignOthers_FixedPlan(others)...)
+		}
+	}
+	return as
+}
+
+func zsAnyMerge(zs *roles.ZoneState) string {
+	for _, r := range zs.Roles {
+		return r.MergeState
+	}
+	return CurrentRules().DefaultMerge
+}
+
+// 不足人数时：其他职业“覆盖+填充”
+func (p *planner) assignOthers_Insufficient(others []*roles.RoleInfo) []Assignment {
+	if len(others) == 0 {
+		return nil
+	}
+	// Lucky9优先，其次强度
+	p.sortByLuckThenStrength(others)
+	// 地图优先级（高->低）
+	order := p.merge.FallbackOrder
+	plan := p.merge.Quotas
+	// 若提供了计划数量，仅保留在计划中的图；否则全部保留
+	filtered := []string{}
+	if len(plan) > 0 {
+		for _, m := range order {
+			if _, ok := plan[m]; ok {
+				filtered = append(filtered, m)
+			}
+		}
+	} else {
+		filtered = order
+	}
+	// 拷贝一份可写计数；plan 为空时用一个大数代表“充足”
+	counts := map[string]int{}
+	if len(plan) > 0 {
+		counts = p.quotas(plan)
+	} else {
+		for _, m := range filtered {
+			counts[m] = 1 << 30
+		}
+	}
+	used := map[string]bool{}
+	res := []Assignment{}
+	// Step1: 覆盖——每张规划图至少放1人（若有可进入者）
+	for _, m := range filtered {
+		if counts[m] <= 0 {
+			continue
+		}
+		// 选择最优可进入的候选
+		for _, r := range others {
+			if used[r.RoleName] {
+				continue
+			}
+			if !p.canEnter(r, m) {
+				continue
+			}
+			used[r.RoleName] = true
+			res = append(res, p.assignTo(r, MapTarget{Map: m, Floor: 1}, BranchCover, others, used))
+			counts[m]--
+			break
+		}
+	}
+	// Step2: 填充——从最高难开始，直至该图数量满足，再到下一图
+	for _, m := range filtered {
+		for counts[m] > 0 {
+			picked := false
+			for _, r := range others {
+				if used[r.RoleName] {
+					continue
+				}
+				if !p.canEnter(r, m) {
+					continue
+				}
+				used[r.RoleName] = true
+				res = append(res, p.assignTo(r, MapTarget{Map: m, Floor: 1}, BranchFill, others, used))
+				counts[m]--
+				picked = true
+				break
+			}
+			if !picked {
+				break
+			} // 没有更多可用人选
+		}
+	}
+	// 若仍有未用完的角色（无配额限制或所有配额未填完但都不适配），按次高难溢出
+	for _, r := range others {
+		if used[r.RoleName] {
+			continue
+		}
+		// 找一个能进的最高难地图
+		for _, m := range filtered {
+			if !p.canEnter(r, m) {
+				continue
+			}
+			used[r.RoleName] = true
+			res = append(res, p.assignTo(r, MapTarget{Map: m, Floor: 1}, BranchOverflow, others, used))
+			break
+		}
+	}
+	return res
+}
+
+// 未合区其他职业分配：完整实现 1)~5) 以及 6) 技能150细则
+func (p *planner) assignOthers_Unmerged(others []*roles.RoleInfo) []Assignment {
+	if len(others) == 0 {
+		return nil
+	}
+	u := p.merge.Unmerged
+	eligible, plan := p.unmergedQuotas(others)
+	// 分配：优先将通天塔数量分给 eligible（幸运9和高道术优先）
+	p.sortByLuckThenStrength(others)
+	used := map[string]bool{}
+	result := []Assignment{}
+	take := func(pool []*roles.RoleInfo, mname, branch string) {
+		need := plan[mname]
+		for _, r := range pool {
+			if need == 0 {
+				break
+			}
+			if used[r.RoleName] || !p.canEnter(r, mname) {
+				continue
+			}
+			used[r.RoleName] = true
+			result = append(result, p.assignTo(r, MapTarget{Map: mname, Floor: 1}, branch, pool, used))
+			need--
+		}
+		plan[mname] = need
+	}
+	// 技能表生效后，技能达标角色按升级顺序先占升级地图（禁地魔穴、远古蛇殿）的名额；
+	// 其中的四主体角色让出的通天塔名额由下一个四主体角色接替，仍不足时由后面的补位步骤补足
+	if len(eligible) >= u.SkillAfterEligible {
+		upgraders := p.skillUpgradeOrder(others)
+		for _, mname := range u.UpgradeMaps {
+			take(upgraders, mname, BranchSkillUpgrade)
+		}
+	}
+	// 再通天塔
+	take(eligible, u.EligibleMap, BranchEligible)
+	// 再禁地魔穴、远古蛇殿（若有）
+	for _, mname := range u.PriorityMaps {
+		take(others, mname, BranchPriority)
+	}
+	// 其余地图按难度从高到低/幸运9优先填充
+	for _, mname := range u.FillOrder {
+		take(others, mname, BranchUnmergedFill)
+	}
+	// 如果仍有未分配的通天塔名额（eligible 不够），用剩余强者填充
+	take(others, u.EligibleMap, BranchEligibleBackfill)
+	// 若还有未分配（名额不足或进入条件不满足），溢出到五蛇殿；进不去则按通用顺序找能进的图
+	overflow := append([]string{u.OverflowMap}, p.rules.FillOrder...)
+	for _, r := range others {
+		if used[r.RoleName] {
+			continue
+		}
+		for _, mname := range overflow {
+			if p.canEnter(r, mname) {
+				used[r.RoleName] = true
+				result = append(result, p.assignTo(r, MapTarget{Map: mname, Floor: 1}, BranchOverflow, others, used))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// skillUpgradeOrder 技能达标角色的升级顺序：技能高者优先，其次幸运达标，再按强度，最后按角色名
+func (p *planner) skillUpgradeOrder(others []*roles.RoleInfo) []*roles.RoleInfo {
+	var out []*roles.RoleInfo
+	for _, r := range others {
+		if r.Skill >= p.rules.SkillHigh {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Skill != b.Skill {
+			return a.Skill > b.Skill
+		}
+		if la, lb := p.luckyHigh(a.Lucky), p.luckyHigh(b.Lucky); la != lb {
+			return la
+		}
+		return p.stronger(a, b)
+	})
+	return out
+}
+
+// unmergedQuotas 未合区配额：先按 A 级四主体人数选表，满 SkillAfterEligible 后再按技能达标人数选表。
+// 返回按强度排序的四主体角色与配额
+func (p *planner) unmergedQuotas(others []*roles.RoleInfo) ([]*roles.RoleInfo, map[string]int) {
+	u := p.merge.Unmerged
+	// 标记具备 A 级及以上四主体（天尊或更高）的角色
+	var eligible []*roles.RoleInfo
+	for _, r := range others {
+		if p.hasATierFourPiece(r) {
+			eligible = append(eligible, r)
+		}
+	}
+	sort.SliceStable(eligible, func(i, j int) bool { return p.stronger(eligible[i], eligible[j]) })
+
+	// 统计技能150人数（仅在 eligible 条件满足 4 个后才应用第6步）
+	skill150 := 0
+	for _, r := range others {
+		if r.Skill >= p.rules.SkillHigh {
+			skill150++
+		}
+	}
+
+	// 先处理 1)~5)：按 eligible 人数选表
+	plan := copyQuotas(p.merge.Quotas)
+	if tb := pickTable(u.EligibleTables, len(eligible)); tb != nil {
+		plan = copyQuotas(tb.Quotas)
+	}
+	// 第6步：当达到4个 eligible 后，按已达成的 skill150 数量动态调整
+	if len(eligible) >= u.SkillAfterEligible {
+		if tb := pickTable(u.SkillTables, skill150); tb != nil {
+			plan = copyQuotas(tb.Quotas)
+		}
+	}
+	return eligible, p.quotas(plan)
+}
+
+// 一合：按规则将最低强度5人分配：机关洞1、五蛇殿2、远古蛇殿2；其余15人动态：通天塔/禁地魔穴与60级后的远古逆魔、地下魔域
+func (p *planner) assignOthers_Merge1(others []*roles.RoleInfo) []Assignment {
+	assign := []Assignment{}
+	if len(others) == 0 {
+		return assign
+	}
+	g := p.merge.Merge1
+	low := min(g.LowCount, len(others))
+	// lowest 5
+	last := len(others)
+	lo := others[last-low:]
+	assign = append(assign, p.distributeByNeed(lo, p.quotas(g.LowQuotas), BranchMerge1Low)...)
+	// remaining
+	rest := others[:last-low]
+	// count of >=60
+	var sixty []*roles.RoleInfo
+	for _, r := range rest {
+		if r.Level >= g.SixtyLevel {
+			sixty = append(sixty, r)
+		}
+	}
+	sort.Slice(sixty, func(i, j int) bool { return p.stronger(sixty[i], sixty[j]) })
+	p.rotate(sixty)
+	tb := pickTable(g.SixtyTables, len(sixty))
+	if tb == nil {
+		return assign
+	}
+	// 60级角色按强度依次占用 地下魔域/远古逆魔 名额，其余人再按配额分配
+	used := map[string]bool{}
+	for _, seat := range tb.Slots {
+		n := p.seatCount(seat.Map, seat.Count)
+		for _, r := range sixty {
+			if n == 0 {
+				break
+			}
+			if used[r.RoleName] || !p.canEnter(r, seat.Map) {
+				continue
+			}
+			used[r.RoleName] = true
+			assign = append(assign, p.assignTo(r, MapTarget{Map: seat.Map, Floor: 1}, BranchMerge1Sixty, sixty, used))
+			n--
+		}
+	}
+	remaining := make([]*roles.RoleInfo, 0, len(rest))
+	for _, r := range rest {
+		if !used[r.RoleName] {
+			remaining = append(remaining, r)
+		}
+	}
+	assign = append(assign, p.distributeByNeed(remaining, p.quotas(tb.Quotas), BranchMerge1Rest)...)
+	return assign
+}
+
+// 二-六合与七合以后：直接按固定人数计划，优先幸运9与高道术
+func (p *planner) assignOthers_FixedPlan(others []*roles.RoleInfo) []Assignment {
+	return p.distributeByNeed(others, p.quotas(p.merge.Quotas), BranchFixed)
+}
+
+// 通用分配：按幸运9优先/强度高优先，逐个角色为其选择能进入且仍有名额的最高难度地图
+func (p *planner) distributeByNeed(others []*roles.RoleInfo, plan map[string]int, branch string) []Assignment {
+	p.sortByLuckThenStrength(others)
+	p.rotate(others)
+	out := []Assignment{}
+	used := map[string]bool{}
+	for _, r := range others {
+		for _, mp := range p.rules.FillOrder {
+			need := plan[mp]
+			if need <= 0 {
+				continue
+			}
+			if !p.canEnter(r, mp) {
+				continue
+			}
+			used[r.RoleName] = true
+			out = append(out, p.assignTo(r, MapTarget{Map: mp, Floor: 1}, branch, others, used))
+			plan[mp] = need - 1
+			break
+		}
+	}
+	return out
+}
+
+// no scheduler here to avoid import cycles; server package calls Plan and pushes
@@ -0,0 +1,154 @@
+package alloc
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"wgserver/internal/services/roles"
+	msgtypes "wgserver/internal/types"
+)
+
+// 日志目录为相对路径，测试在临时目录中运行以免在源码树中生成日志
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "alloc-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var testNow = time.Date(2026, 10, 14, 12, 0, 0, 0, ScheduleLoc) // 周三，默认规则下无时段窗口
+
+// tianzun 天尊四主体（A 级）
+var tianzun = []msgtypes.EquipItem{
+	{Slot: "头盔", Name: "天尊头盔"}, {Slot: "项链", Name: "天尊项链"},
+	{Slot: "手镯1", Name: "天尊手镯"}, {Slot: "戒指1", Name: "天尊戒指"},
+}
+
+func testRole(name, class string, level, skill, lucky, magic int) *roles.RoleInfo {
+	return &roles.RoleInfo{RoleAttributes: msgtypes.RoleAttributes{
+		RoleName: name, Zone: "测试区", MergeState: "未合区", Class: class,
+		Level: level, Skill: skill, Lucky: lucky, Magic: magic, Equipments: tianzun,
+	}}
+}
+
+// 技能达标角色按期望的升级顺序排列：技能高者 → 幸运达标 → 强度 → 角色名
+var upgradeSpecs = []struct {
+	name                string
+	skill, lucky, magic int
+}{
+	{"s01", 180, 0, 100},
+	{"s02", 170, 9, 100}, // 与 s03 技能相同，幸运达标优先于道术
+	{"s03", 170, 0, 900},
+	{"s04", 160, 9, 500}, // 与 s05 技能、幸运相同，强度高者优先
+	{"s05", 160, 9, 300},
+	{"s06", 155, 0, 400}, // s06~s08 完全相同，按角色名
+	{"s07", 155, 0, 400},
+	{"s08", 155, 0, 400},
+	{"s09", 150, 9, 200},
+	{"s10", 150, 0, 800},
+	{"s11", 150, 0, 700},
+	{"s12", 150, 0, 600},
+}
+
+// unmergedOthers 未合区道士角色（强度按道术比较）：前 n 个技能达标，其余技能 100，人数至少 10（满员扣除 2 个法师层）；
+// 全员具备天尊四主体，技能表生效。倒序返回，结果不依赖输入顺序。
+func unmergedOthers(n int) []*roles.RoleInfo {
+	var out []*roles.RoleInfo
+	for i := 0; i < n; i++ {
+		s := upgradeSpecs[i]
+		out = append(out, testRole(s.name, "道士", 55, s.skill, s.lucky, s.magic))
+	}
+	for i := n; i < 10; i++ {
+		out = append(out, testRole(fmt.Sprintf("f%02d", i), "道士", 55, 100, 0, 50+i))
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func TestUnmergedSkillUpgrade(t *testing.T) {
+	cases := []struct {
+		skill150 int
+		quotas   map[string]int
+		jindi    []string // 进入禁地魔穴的技能达标角色（按升级顺序）
+		shedian  []string // 进入远古蛇殿的技能达标角色
+	}{
+		{0, map[string]int{"将军坟": 1, "机关洞": 2, "五蛇殿": 3, "通天塔": 4}, nil, nil},
+		{1, map[string]int{"将军坟": 1, "机关洞": 2, "五蛇殿": 2, "通天塔": 4, "禁地魔穴": 1}, []string{"s01"}, nil},
+		{2, map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 2, "通天塔": 4, "禁地魔穴": 2}, []string{"s01", "s02"}, nil},
+		{3, map[string]int{"将军坟": 1, "机关洞": 1, "五蛇殿": 1, "通天塔": 4, "禁地魔穴": 3}, []string{"s01", "s02", "s03"}, nil},
+		{4, map[string]int{"机关洞": 1, "五蛇殿": 1, "通天塔": 4, "禁地魔穴": 3, "远古蛇殿": 1},
+			[]string{"s01", "s02", "s03"}, []string{"s04"}},
+		{5, map[string]int{"五蛇殿": 1, "通天塔": 4, "禁地魔穴": 4, "远古蛇殿": 1},
+			[]string{"s01", "s02", "s03", "s04"}, []string{"s05"}},
+		{6, map[string]int{"通天塔": 4, "禁地魔穴": 4, "远古蛇殿": 2},
+			[]string{"s01", "s02", "s03", "s04"}, []string{"s05", "s06"}},
+		{7, map[string]int{"通天塔": 3, "禁地魔穴": 4, "远古蛇殿": 3},
+			[]string{"s01", "s02", "s03", "s04"}, []string{"s05", "s06", "s07"}},
+		{8, map[string]int{"通天塔": 2, "禁地魔穴": 5, "远古蛇殿": 3},
+			[]string{"s01", "s02", "s03", "s04", "s05"}, []string{"s06", "s07", "s08"}},
+		{9, map[string]int{"通天塔": 2, "禁地魔穴": 5, "远古蛇殿": 3},
+			[]string{"s01", "s02", "s03", "s04", "s05"}, []string{"s06", "s07", "s08"}},
+		{10, map[string]int{"通天塔": 2, "禁地魔穴": 5, "远古蛇殿": 3},
+			[]string{"s01", "s02", "s03", "s04", "s05"}, []string{"s06", "s07", "s08"}},
+		{11, map[string]int{"通天塔": 2, "禁地魔穴": 5, "远古蛇殿": 3},
+			[]string{"s01", "s02", "s03", "s04", "s05"}, []string{"s06", "s07", "s08"}},
+		{12, map[string]int{"通天塔": 2, "禁地魔穴": 5, "远古蛇殿": 3},
+			[]string{"s01", "s02", "s03", "s04", "s05"}, []string{"s06", "s07", "s08"}},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("skill150=%d", tc.skill150), func(t *testing.T) {
+			p := newPlanner("未合区", testNow)
+			others := unmergedOthers(tc.skill150)
+
+			if _, plan := p.unmergedQuotas(others); !reflect.DeepEqual(nonZero(plan), tc.quotas) {
+				t.Fatalf("quotas = %v, want %v", nonZero(plan), tc.quotas)
+			}
+
+			as := p.assignOthers_Unmerged(others)
+			got := map[string][]string{}
+			count := map[string]int{}
+			for _, a := range as {
+				count[a.Target.Map]++
+				if a.Reason.Branch == BranchSkillUpgrade {
+					got[a.Target.Map] = append(got[a.Target.Map], a.RoleName)
+				}
+			}
+			if !reflect.DeepEqual(got["禁地魔穴"], tc.jindi) {
+				t.Errorf("禁地魔穴 upgraders = %v, want %v", got["禁地魔穴"], tc.jindi)
+			}
+			if !reflect.DeepEqual(got["远古蛇殿"], tc.shedian) {
+				t.Errorf("远古蛇殿 upgraders = %v, want %v", got["远古蛇殿"], tc.shedian)
+			}
+			// 不足 10 人时名额恰好用满；更多人时多出的角色溢出到五蛇殿
+			want := tc.quotas
+			if extra := len(others) - 10; extra > 0 {
+				want = copyQuotas(tc.quotas)
+				want[p.merge.Unmerged.OverflowMap] += extra
+			}
+			if !reflect.DeepEqual(count, want) {
+				t.Errorf("assigned per map = %v, want %v", count, want)
+			}
+		})
+	}
+}
+
+func nonZero(q map[string]int) map[string]int {
+	out := map[string]int{}
+	for m, n := range q {
+		if n > 0 {
+			out[m] = n
+		}
+	}
+	return out
+}
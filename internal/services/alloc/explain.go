@@ -0,0 +1,143 @@
+package alloc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 分配说明：每条 Assignment 记录选中它的规则分支、角色排名与强度、同一轮的竞争者，
+// 以及该角色无法进入的地图，供管理接口与 map_allocation 日志回答“为什么在这里”。
+
+// 规则分支
+const (
+	BranchMageFixed        = "mage_fixed"             // 法师固定层
+	BranchMageInsufficient = "mage_insufficient"      // 人数不足时法师按高难顺序
+	BranchCover            = "insufficient_cover"     // 人数不足：每图先放 1 人
+	BranchFill             = "insufficient_fill"      // 人数不足：按高难顺序补满
+	BranchOverflow         = "overflow"               // 名额用尽或进入条件不满足后的兜底
+	BranchEligible         = "unmerged_eligible"      // 未合区：A 级四主体进入 EligibleMap
+	BranchPriority         = "unmerged_priority"      // 未合区：优先地图
+	BranchUnmergedFill     = "unmerged_fill"          // 未合区：其余地图按顺序填充
+	BranchEligibleBackfill = "unmerged_backfill"      // 未合区：EligibleMap 名额由非四主体补足
+	BranchSkillUpgrade     = "unmerged_skill_upgrade" // 未合区：技能达标角色按升级顺序进入升级地图
+	BranchMerge1Low        = "merge1_low"             // 一合：最弱几人
+	BranchMerge1Sixty      = "merge1_sixty_slot"      // 一合：60 级角色按强度占用高难名额
+	BranchMerge1Rest       = "merge1_rest"            // 一合：其余按配额
+	BranchFixed            = "fixed"                  // 二合及以后：固定配额
+	BranchPinned           = "pinned"                 // 管理员固定分配
+)
+
+// maxCompetitors 每条说明最多记录的竞争者数
+const maxCompetitors = 3
+
+// Candidate 竞争者：选中时仍未分配、同样可进入该地图、排在其后的角色
+type Candidate struct {
+	Role  string `json:"role"`
+	Rank  int    `json:"rank"`
+	Score int    `json:"score"`
+}
+
+type Reason struct {
+	Branch      string         `json:"branch"`
+	Rank        int            `json:"rank"`                // 全区强度排名，从 1 开始
+	Score       int            `json:"score"`               // strengthScore
+	Breakdown   map[string]int `json:"breakdown,omitempty"` // 强度各项得分，见 scorer.go
+	Competitors []Candidate    `json:"competitors,omitempty"`
+	Blocked     []string       `json:"blocked,omitempty"`      // 无法进入的地图及原因
+	SwappedWith string         `json:"swapped_with,omitempty"` // 稳定性交换的对方角色
+}
+
+// assignTo 生成一条带说明的分配；pool 为本轮候选（按优先顺序），used 为已分配角色
+func (p *planner) assignTo(r *roles.RoleInfo, target MapTarget, branch string, pool []*roles.RoleInfo, used map[string]bool) Assignment {
+	reason := &Reason{Branch: branch}
+	for _, c := range pool {
+		if len(reason.Competitors) >= maxCompetitors {
+			break
+		}
+		if c == r || used[c.RoleName] || p.requirementFailure(c, target.Map) != "" {
+			continue
+		}
+		reason.Competitors = append(reason.Competitors, Candidate{Role: c.RoleName})
+	}
+	return Assignment{RoleName: r.RoleName, Target: target, Reason: reason}
+}
+
+// explain 补全排名、强度与进入限制（在全部分支与稳定性交换之后调用）
+func (p *planner) explain(zs *roles.ZoneState, as []Assignment) {
+	ranked := make([]*roles.RoleInfo, 0, len(zs.Roles))
+	for _, r := range zs.Roles {
+		ranked = append(ranked, r)
+	}
+	sort.Slice(ranked, func(i, j int) bool { return p.stronger(ranked[i], ranked[j]) })
+	rank := make(map[string]int, len(ranked))
+	for i, r := range ranked {
+		rank[r.RoleName] = i + 1
+	}
+	blocked := map[string][]string{}
+	for _, rj := range p.rejections {
+		blocked[rj.Role] = append(blocked[rj.Role], rj.Map+"("+rj.Reason+")")
+	}
+	for i := range as {
+		a := &as[i]
+		if a.Reason == nil {
+			a.Reason = &Reason{}
+		}
+		if r := zs.Roles[a.RoleName]; r != nil {
+			a.Reason.Rank = rank[a.RoleName]
+			b := p.score(r)
+			a.Reason.Score, a.Reason.Breakdown = b.Total, b.Parts
+		}
+		a.Reason.Blocked = blocked[a.RoleName]
+		// 稳定性交换后说明随名额转移，去掉持有者本人
+		cs := a.Reason.Competitors[:0]
+		for _, c := range a.Reason.Competitors {
+			if c.Role == a.RoleName {
+				continue
+			}
+			c.Rank = rank[c.Role]
+			if r := zs.Roles[c.Role]; r != nil {
+				c.Score = p.strengthScore(r)
+			}
+			cs = append(cs, c)
+		}
+		a.Reason.Competitors = cs
+	}
+}
+
+// String 单行文本，用于日志
+func (rs *Reason) String() string {
+	if rs == nil {
+		return "-"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "branch=%s rank=%d score=%d", rs.Branch, rs.Rank, rs.Score)
+	if len(rs.Breakdown) > 0 {
+		b.WriteString("(" + ScoreBreakdown{Parts: rs.Breakdown}.String() + ")")
+	}
+	if len(rs.Competitors) > 0 {
+		cs := make([]string, len(rs.Competitors))
+		for i, c := range rs.Competitors {
+			cs[i] = fmt.Sprintf("%s#%d(%d)", c.Role, c.Rank, c.Score)
+		}
+		b.WriteString(" competitors=" + strings.Join(cs, ","))
+	}
+	if rs.SwappedWith != "" {
+		b.WriteString(" swapped_with=" + rs.SwappedWith)
+	}
+	if len(rs.Blocked) > 0 {
+		b.WriteString(" blocked=" + strings.Join(rs.Blocked, ","))
+	}
+	return b.String()
+}
+
+func logExplanations(zone string, as []Assignment) {
+	sorted := append([]Assignment(nil), as...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].RoleName < sorted[j].RoleName })
+	for _, a := range sorted {
+		logger.MapAlloc().Printf("zone=%s explain role=%s map=%s floor=%d %s", zone, a.RoleName, a.Target.Map, a.Target.Floor, a.Reason)
+	}
+}
@@ -0,0 +1,339 @@
+package alloc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"wgserver/internal/db"
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 公平轮换（可选）：按规划历史统计每个角色最近若干天在各价值层级地图上被分配的累计时长，
+// 通用分配（distributeByNeed）与一合 60 级名额排序时，把强度名次与累计时长名次（少者在前）按区服权重混合，
+// 让较弱但满足进入条件的角色轮流进入高收益地图。权重 0 为原强度排序；法师固定层与 pin 不参与轮换。
+
+// FairnessRules 规则文件中的公平轮换配置
+type FairnessRules struct {
+	Tiers  []FairnessTier     `json:"tiers"`  // 价值层级，由高到低
+	Days   int                `json:"days"`   // 统计最近多少天（UTC+8 自然日）的累计时长
+	Weight float64            `json:"weight"` // 默认权重 0~1，0 关闭
+	Zones  map[string]float64 `json:"zones"`  // 区服 -> 权重，覆盖默认
+}
+
+type FairnessTier struct {
+	Name string   `json:"name"`
+	Maps []string `json:"maps"`
+}
+
+// WeightFor 区服的公平权重
+func (f *FairnessRules) WeightFor(zone string) float64 {
+	if w, ok := f.Zones[zone]; ok {
+		return w
+	}
+	return f.Weight
+}
+
+// tierOf 地图所属层级，不在任何层级时为空
+func (f *FairnessRules) tierOf(m string) string {
+	for _, t := range f.Tiers {
+		if containsString(t.Maps, m) {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+func (f *FairnessRules) validate(known map[string]bool) []error {
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	seen := map[string]string{}
+	names := map[string]bool{}
+	for i, t := range f.Tiers {
+		if t.Name == "" || names[t.Name] {
+			bad("fairness.tiers[%d]: empty or duplicate name", i)
+		}
+		names[t.Name] = true
+		for _, m := range t.Maps {
+			if !known[m] {
+				bad("fairness.tiers.%s: unknown map %q", t.Name, m)
+			}
+			if other, dup := seen[m]; dup {
+				bad("fairness.tiers.%s: map %s already in tier %s", t.Name, m, other)
+			}
+			seen[m] = t.Name
+		}
+	}
+	check := func(where string, w float64) {
+		if w < 0 || w > 1 {
+			bad("%s: weight must be within [0,1]", where)
+		}
+		if w > 0 && (f.Days < 1 || len(f.Tiers) == 0) {
+			bad("%s: weight requires days >= 1 and at least one tier", where)
+		}
+	}
+	check("fairness.weight", f.Weight)
+	for _, z := range sortedKeys(f.Zones) {
+		check("fairness.zones."+z, f.Zones[z])
+	}
+	return errs
+}
+
+// 累计时长：zone -> UTC+8 日期 -> 角色 -> 层级 -> 时长
+var usage = struct {
+	mu sync.Mutex
+	m  map[string]map[string]map[string]map[string]time.Duration
+}{m: map[string]map[string]map[string]map[string]time.Duration{}}
+
+// accrueInto 把一个计划版本在 [from, to) 内的分配时长按自然日计入 dst
+func accrueInto(dst map[string]map[string]map[string]time.Duration, f *FairnessRules, as []Assignment, from, to time.Time) {
+	if len(f.Tiers) == 0 || !to.After(from) {
+		return
+	}
+	for t := from; t.Before(to); {
+		local := t.In(ScheduleLoc)
+		next := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, ScheduleLoc)
+		end := to
+		if next.Before(end) {
+			end = next
+		}
+		day := local.Format(time.DateOnly)
+		for _, a := range as {
+			tier := f.tierOf(a.Target.Map)
+			if tier == "" {
+				continue
+			}
+			if dst[day] == nil {
+				dst[day] = map[string]map[string]time.Duration{}
+			}
+			if dst[day][a.RoleName] == nil {
+				dst[day][a.RoleName] = map[string]time.Duration{}
+			}
+			dst[day][a.RoleName][tier] += end.Sub(t)
+		}
+		t = end
+	}
+}
+
+// accrueUsage 新版本生效时结算上一版本的时长，并清理统计窗口以外的日期
+func accrueUsage(zone string, prev []Assignment, from, to time.Time) {
+	f := CurrentRules().Fairness
+	if len(f.Tiers) == 0 {
+		return
+	}
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	zm := usage.m[zone]
+	if zm == nil {
+		zm = map[string]map[string]map[string]time.Duration{}
+		usage.m[zone] = zm
+	}
+	accrueInto(zm, &f, prev, from, to)
+	oldest := windowStart(&f, to)
+	for day := range zm {
+		if day < oldest {
+			delete(zm, day)
+		}
+	}
+}
+
+// windowStart 统计窗口的第一天（UTC+8 日期）
+func windowStart(f *FairnessRules, now time.Time) string {
+	days := max(f.Days, 1)
+	return now.In(ScheduleLoc).AddDate(0, 0, 1-days).Format(time.DateOnly)
+}
+
+// TierUsage 区服各角色最近 days 天在各层级的累计分配时长（含当前计划至 now 的部分）
+func TierUsage(zone string, now time.Time) map[string]map[string]time.Duration {
+	f := CurrentRules().Fairness
+	out := map[string]map[string]time.Duration{}
+	if len(f.Tiers) == 0 {
+		return out
+	}
+	days := map[string]map[string]map[string]time.Duration{}
+	history.mu.RLock()
+	if vs := history.m[zone]; len(vs) > 0 {
+		cur := vs[len(vs)-1]
+		accrueInto(days, &f, cur.Assignments, cur.At, now)
+	}
+	history.mu.RUnlock()
+	oldest := windowStart(&f, now)
+	add := func(src map[string]map[string]map[string]time.Duration) {
+		for day, byRole := range src {
+			if day < oldest {
+				continue
+			}
+			for role, byTier := range byRole {
+				if out[role] == nil {
+					out[role] = map[string]time.Duration{}
+				}
+				for tier, d := range byTier {
+					out[role][tier] += d
+				}
+			}
+		}
+	}
+	add(days)
+	usage.mu.Lock()
+	add(usage.m[zone])
+	usage.mu.Unlock()
+	return out
+}
+
+// usageVersion 重建累计时长用的历史版本（只含层级地图上的分配）
+type usageVersion struct {
+	at time.Time
+	as []Assignment
+}
+
+// loadUsage 启动时按数据库中统计窗口内的规划历史重建累计时长。
+// 须在 LoadPlanHistory 载入各区服最新版本之后调用：最新版本至今的时长不在这里结算，
+// 由 TierUsage 按 history 中的最新版本实时计入，下一个版本记录时再由 accrueUsage 结算
+func loadUsage(now time.Time) error {
+	f := CurrentRules().Fairness
+	if len(f.Tiers) == 0 || db.DB() == nil {
+		return nil
+	}
+	start, err := time.ParseInLocation(time.DateOnly, windowStart(&f, now), ScheduleLoc)
+	if err != nil {
+		return err
+	}
+	var maps []string
+	for _, t := range f.Tiers {
+		maps = append(maps, t.Maps...)
+	}
+	in, args := db.InClause("a.map_name", maps)
+	var rows []struct {
+		Zone   string    `db:"zone"`
+		ID     int64     `db:"id"`
+		At     time.Time `db:"created_at"`
+		Role   *string   `db:"role_name"`
+		Map    *string   `db:"map_name"`
+		Floor  *int      `db:"floor"`
+		Branch *string   `db:"branch"`
+	}
+	// 窗口开始前最后一个版本也计入（其时长从窗口开始算起）
+	q := `SELECT h.zone, h.id, h.created_at, a.role_name, a.map_name, a.floor, a.branch
+		FROM plan_history h LEFT JOIN map_allocations a ON a.plan_id=h.id AND ` + in + `
+		WHERE h.created_at >= (SELECT COALESCE(MAX(p.created_at), ?) FROM plan_history p WHERE p.zone=h.zone AND p.created_at<=?)
+		ORDER BY h.zone, h.version`
+	if err := db.DB().Select(&rows, q, append(args, start, start)...); err != nil {
+		return err
+	}
+	byZone := map[string][]*usageVersion{}
+	var last *usageVersion
+	var lastID int64
+	for _, r := range rows {
+		if last == nil || r.ID != lastID {
+			last = &usageVersion{at: r.At}
+			lastID = r.ID
+			byZone[r.Zone] = append(byZone[r.Zone], last)
+		}
+		if r.Role != nil {
+			a := allocationRow{Role: *r.Role, Map: *r.Map, Floor: 1, Branch: *r.Branch}
+			if r.Floor != nil {
+				a.Floor = *r.Floor
+			}
+			last.as = append(last.as, a.assignment())
+		}
+	}
+	rebuildUsage(&f, byZone, start)
+	logger.MapAlloc().Printf("fairness usage rebuilt zones=%d since=%s", len(byZone), start.Format(time.DateOnly))
+	return nil
+}
+
+// rebuildUsage 按版本顺序结算相邻版本之间的时长（早于 start 的部分不计），替换各区服的累计时长；
+// 每个区服的最后一个版本不结算，见 loadUsage
+func rebuildUsage(f *FairnessRules, byZone map[string][]*usageVersion, start time.Time) {
+	usage.mu.Lock()
+	defer usage.mu.Unlock()
+	for zone, vs := range byZone {
+		zm := map[string]map[string]map[string]time.Duration{}
+		for i := 0; i+1 < len(vs); i++ {
+			from := vs[i].at
+			if from.Before(start) {
+				from = start
+			}
+			accrueInto(zm, f, vs[i].as, from, vs[i+1].at)
+		}
+		usage.m[zone] = zm
+	}
+}
+
+// fairnessFor 规划开始时载入区服权重与累计时长
+func (p *planner) fairnessFor(zone string) {
+	p.fairWeight = p.rules.Fairness.WeightFor(zone)
+	if p.fairWeight <= 0 {
+		return
+	}
+	p.usage = TierUsage(zone, p.now)
+}
+
+// lessUsed 按层级由高到低比较累计时长，少者在前
+func (p *planner) lessUsed(a, b *roles.RoleInfo) bool {
+	ua, ub := p.usage[a.RoleName], p.usage[b.RoleName]
+	for _, t := range p.rules.Fairness.Tiers {
+		if ua[t.Name] != ub[t.Name] {
+			return ua[t.Name] < ub[t.Name]
+		}
+	}
+	return false
+}
+
+// rotate 公平轮换：只重排能进入任一层级地图的角色（在它们原有的位置之间），
+// 排序键为 (1-权重)×强度名次 + 权重×累计时长名次，相同时强度高者在前
+func (p *planner) rotate(list []*roles.RoleInfo) {
+	if p.fairWeight <= 0 {
+		return
+	}
+	var slots []int
+	for i, r := range list {
+		for _, t := range p.rules.Fairness.Tiers {
+			if p.canEnterAny(r, t.Maps) {
+				slots = append(slots, i)
+				break
+			}
+		}
+	}
+	if len(slots) < 2 {
+		return
+	}
+	cand := make([]*roles.RoleInfo, len(slots))
+	strengthRank := make(map[string]int, len(slots))
+	for k, i := range slots {
+		cand[k] = list[i]
+		strengthRank[list[i].RoleName] = k
+	}
+	byUsage := append([]*roles.RoleInfo(nil), cand...)
+	sort.SliceStable(byUsage, func(i, j int) bool { return p.lessUsed(byUsage[i], byUsage[j]) })
+	key := make(map[string]float64, len(slots))
+	for k, r := range byUsage {
+		key[r.RoleName] = (1-p.fairWeight)*float64(strengthRank[r.RoleName]) + p.fairWeight*float64(k)
+	}
+	sort.SliceStable(cand, func(i, j int) bool {
+		ki, kj := key[cand[i].RoleName], key[cand[j].RoleName]
+		if ki != kj {
+			return ki < kj
+		}
+		return strengthRank[cand[i].RoleName] < strengthRank[cand[j].RoleName]
+	})
+	for k, i := range slots {
+		list[i] = cand[k]
+	}
+}
+
+func (p *planner) canEnterAny(r *roles.RoleInfo, maps []string) bool {
+	for _, m := range maps {
+		if p.canEnter(r, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// fairnessSwapBlocked 公平轮换生效时，稳定性交换不跨层级交换目标（否则会把轮换出去的名额换回来）
+func (p *planner) fairnessSwapBlocked(a, b MapTarget) bool {
+	return p.fairWeight > 0 && p.rules.Fairness.tierOf(a.Map) != p.rules.Fairness.tierOf(b.Map)
+}
@@ -0,0 +1,156 @@
+package alloc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"wgserver/internal/services/roles"
+)
+
+var highTier = FairnessRules{Tiers: []FairnessTier{{Name: "high", Maps: []string{"地下魔域"}}}, Days: 7}
+
+// useFairness 测试期间替换公平轮换配置
+func useFairness(t *testing.T, f FairnessRules) {
+	orig := CurrentRules()
+	rl := *orig
+	rl.Fairness = f
+	currentRules.Store(&rl)
+	t.Cleanup(func() { currentRules.Store(orig) })
+}
+
+func at8(day, hh, mm int) time.Time { return time.Date(2026, 10, day, hh, mm, 0, 0, ScheduleLoc) }
+
+func TestAccrueIntoSplitsDays(t *testing.T) {
+	as := []Assignment{
+		{RoleName: "A", Target: MapTarget{Map: "地下魔域", Floor: 2}},
+		{RoleName: "B", Target: MapTarget{Map: "通天塔", Floor: 1}}, // 不在任何层级
+	}
+	dst := map[string]map[string]map[string]time.Duration{}
+	// UTC+8 午夜前后各一段；输入用 UTC 表示，按 UTC+8 的自然日切分
+	accrueInto(dst, &highTier, as, at8(14, 22, 30).UTC(), at8(15, 1, 15).UTC())
+	want := map[string]map[string]map[string]time.Duration{
+		"2026-10-14": {"A": {"high": 90 * time.Minute}},
+		"2026-10-15": {"A": {"high": 75 * time.Minute}},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Fatalf("usage = %v, want %v", dst, want)
+	}
+	accrueInto(dst, &highTier, as, at8(15, 2, 0), at8(15, 2, 0))
+	if !reflect.DeepEqual(dst, want) {
+		t.Fatalf("empty interval changed usage: %v", dst)
+	}
+}
+
+// 重启后按数据库重建的累计时长加上最新版本至今的时长，应与不重启时一致，且后续版本继续累计
+func TestTierUsageAfterRestart(t *testing.T) {
+	useFairness(t, highTier)
+	const live, restarted = "公平不重启", "公平重启"
+	t.Cleanup(func() {
+		history.mu.Lock()
+		delete(history.m, live)
+		delete(history.m, restarted)
+		history.mu.Unlock()
+		usage.mu.Lock()
+		delete(usage.m, live)
+		delete(usage.m, restarted)
+		usage.mu.Unlock()
+	})
+	in := func(roles ...string) PlanResult {
+		var res PlanResult
+		for _, r := range roles {
+			res.Assignments = append(res.Assignments, Assignment{RoleName: r, Target: MapTarget{Map: "地下魔域", Floor: 1}})
+		}
+		return res
+	}
+	plans := []struct {
+		at  time.Time
+		res PlanResult
+	}{
+		{at8(13, 20, 0), in("A", "B")},
+		{at8(14, 2, 0), in("A")},
+		{at8(14, 9, 0), in("B")},
+	}
+	for _, z := range []string{live, restarted} {
+		for _, p := range plans {
+			RecordPlan(z, "test", p.res, p.at)
+		}
+	}
+
+	// 模拟重启：LoadPlanHistory 只保留最新版本，loadUsage 按全部版本重建
+	history.mu.Lock()
+	vs := history.m[restarted]
+	var loaded []*usageVersion
+	for _, v := range vs {
+		loaded = append(loaded, &usageVersion{at: v.At, as: v.Assignments})
+	}
+	history.m[restarted] = vs[len(vs)-1:]
+	history.mu.Unlock()
+	usage.mu.Lock()
+	delete(usage.m, restarted)
+	usage.mu.Unlock()
+	f := CurrentRules().Fairness
+	start, _ := time.ParseInLocation(time.DateOnly, windowStart(&f, at8(14, 12, 0)), ScheduleLoc)
+	rebuildUsage(&f, map[string][]*usageVersion{restarted: loaded}, start)
+
+	now := at8(14, 12, 0)
+	want := map[string]map[string]time.Duration{
+		"A": {"high": 13 * time.Hour},            // 20:00~09:00
+		"B": {"high": 6*time.Hour + 3*time.Hour}, // 20:00~02:00 + 09:00~12:00
+	}
+	for _, z := range []string{live, restarted} {
+		if got := TierUsage(z, now); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: usage at %s = %v, want %v", z, now.Format(time.DateTime), got, want)
+		}
+	}
+
+	for _, z := range []string{live, restarted} {
+		RecordPlan(z, "test", in("A"), at8(14, 15, 0))
+	}
+	later := at8(14, 16, 0)
+	if a, b := TierUsage(live, later), TierUsage(restarted, later); !reflect.DeepEqual(a, b) {
+		t.Errorf("after next version: live %v, restarted %v", a, b)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	p := newPlanner("七合", testNow)
+	rl := *p.rules
+	rl.Fairness = highTier
+	p.rules = &rl
+	list := []*roles.RoleInfo{
+		testRole("s1", "道士", 65, 100, 0, 900),
+		testRole("s2", "道士", 65, 100, 0, 800),
+		testRole("low", "道士", 50, 100, 0, 700), // 进不了 地下魔域（60 级），位置不变
+		testRole("s3", "道士", 65, 100, 0, 600),
+	}
+	p.usage = map[string]map[string]time.Duration{
+		"s1": {"high": 10 * time.Hour},
+		"s2": {"high": 5 * time.Hour},
+	}
+	names := func(l []*roles.RoleInfo) []string {
+		out := make([]string, len(l))
+		for i, r := range l {
+			out[i] = r.RoleName
+		}
+		return out
+	}
+	cases := []struct {
+		weight float64
+		want   []string
+	}{
+		{0, []string{"s1", "s2", "low", "s3"}},
+		// 强度名次 s1 0 / s2 1 / s3 2，时长名次 s3 0 / s2 1 / s1 2：0.5 时三者同分，按强度
+		{0.5, []string{"s1", "s2", "low", "s3"}},
+		{0.75, []string{"s3", "s2", "low", "s1"}},
+		{1, []string{"s3", "s2", "low", "s1"}},
+	}
+	for _, tc := range cases {
+		l := append([]*roles.RoleInfo(nil), list...)
+		p.fairWeight = tc.weight
+		p.rotate(l)
+		if got := names(l); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("weight %.2f: order = %v, want %v", tc.weight, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package alloc
+
+import (
+	"sort"
+
+	"wgserver/internal/services/roles"
+)
+
+// 层数规划：多层地图按 floors 配置的每层容量把非法师角色分散到各层，避免全部挤在 1 层。
+// 法师固定层与 pin 已指定的层保持不变，但计入对应层的占用。
+
+// FloorCapacity 多层地图中一层的容量
+type FloorCapacity struct {
+	Floor    int `json:"floor"`
+	Capacity int `json:"capacity"`
+	MinLevel int `json:"min_level,omitempty"` // 进入该层的最低等级，0 表示不限
+}
+
+// spreadFloors 为配置了层容量的地图分配层数：
+// 上一轮已在该图且所在层仍有空位的角色保留原层；其余按强度由高到低放入可进入的最高层，
+// 各层都满时留在最低一层
+func (p *planner) spreadFloors(zs *roles.ZoneState, as []Assignment, prev []Assignment) {
+	if len(p.rules.Floors) == 0 {
+		return
+	}
+	prevOf := make(map[string]MapTarget, len(prev))
+	for _, a := range prev {
+		prevOf[a.RoleName] = a.Target
+	}
+	byMap := map[string][]int{}
+	used := map[MapTarget]int{}
+	for i, a := range as {
+		if _, ok := p.rules.Floors[a.Target.Map]; !ok {
+			continue
+		}
+		if pinned(a) || mageSeat(a) {
+			used[a.Target]++
+			continue
+		}
+		byMap[a.Target.Map] = append(byMap[a.Target.Map], i)
+	}
+	for _, m := range sortedKeys(byMap) {
+		floors := p.rules.Floors[m]
+		idx := byMap[m]
+		sort.Slice(idx, func(x, y int) bool {
+			rx, ry := zs.Roles[as[idx[x]].RoleName], zs.Roles[as[idx[y]].RoleName]
+			if rx == nil || ry == nil {
+				return as[idx[x]].RoleName < as[idx[y]].RoleName
+			}
+			return p.stronger(rx, ry)
+		})
+		fits := func(r *roles.RoleInfo, fc FloorCapacity) bool {
+			return used[MapTarget{Map: m, Floor: fc.Floor}] < fc.Capacity && (r == nil || r.Level >= fc.MinLevel)
+		}
+		var rest []int
+		for _, i := range idx {
+			pt, ok := prevOf[as[i].RoleName]
+			placed := false
+			if ok && pt.Map == m {
+				for _, fc := range floors {
+					if fc.Floor == pt.Floor && fits(zs.Roles[as[i].RoleName], fc) {
+						as[i].Target.Floor = fc.Floor
+						used[pt]++
+						placed = true
+						break
+					}
+				}
+			}
+			if !placed {
+				rest = append(rest, i)
+			}
+		}
+		lowest := floors[0].Floor
+		for _, fc := range floors {
+			lowest = min(lowest, fc.Floor)
+		}
+		for _, i := range rest {
+			r := zs.Roles[as[i].RoleName]
+			best := 0
+			for _, fc := range floors {
+				if fc.Floor > best && fits(r, fc) {
+					best = fc.Floor
+				}
+			}
+			if best == 0 {
+				best = lowest
+			}
+			as[i].Target.Floor = best
+			used[MapTarget{Map: m, Floor: best}]++
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package alloc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wgserver/db/migrations"
+	"wgserver/internal/config"
+	"wgserver/internal/db"
+	"wgserver/internal/logger"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 规划历史：每次规划（完整规划或空缺递补）记为区服内递增的一个版本，保存触发原因、各角色目标
+// 以及相对上一版本的变化，写入 plan_history / map_allocations。内存中保留每个区服最近的若干版本，
+// 未连接数据库时查询只覆盖这一部分。
+
+// historyMemKeep 内存中每个区服保留的版本数
+const historyMemKeep = 200
+
+var historyRetention = sync.OnceValue(func() time.Duration { return config.Load().PlanHistoryRetention })
+
+// PlanChange 相对上一版本的目标变化
+type PlanChange struct {
+	Role string     `json:"role"`
+	From *MapTarget `json:"from,omitempty"` // 为空表示上一版本未分配
+	To   *MapTarget `json:"to,omitempty"`   // 为空表示本版本未分配
+}
+
+// PlanVersion 一个规划版本
+type PlanVersion struct {
+	Zone        string       `json:"zone"`
+	Version     int          `json:"version"`
+	Reason      string       `json:"reason"` // 触发原因
+	Schedule    string       `json:"schedule,omitempty"`
+	Moves       int          `json:"moves"`
+	At          time.Time    `json:"at"`
+	Assignments []Assignment `json:"assignments,omitempty"` // 只含分配分支（Reason.Branch）
+	Changes     []PlanChange `json:"changes"`
+}
+
+// TimelineEntry 角色在一段时间内保持的目标，Until 为空表示至今
+type TimelineEntry struct {
+	Zone    string     `json:"zone"`
+	Version int        `json:"version"` // 目标开始生效的版本
+	Reason  string     `json:"reason"`
+	Target  *MapTarget `json:"target"` // 为空表示该区服计划中未分配
+	Branch  string     `json:"branch,omitempty"`
+	From    time.Time  `json:"from"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+var history = struct {
+	mu sync.RWMutex
+	m  map[string][]*PlanVersion // zone -> 版本（升序）
+}{m: map[string][]*PlanVersion{}}
+
+// RecordPlan 记录一个规划版本并交给后台写库（见 StartHistoryWriter），返回该版本
+func RecordPlan(zone, reason string, res PlanResult, at time.Time) PlanVersion {
+	as := make([]Assignment, len(res.Assignments))
+	for i, a := range res.Assignments {
+		as[i] = Assignment{RoleName: a.RoleName, Target: a.Target}
+		if a.Reason != nil && a.Reason.Branch != "" {
+			as[i].Reason = &Reason{Branch: a.Reason.Branch}
+		}
+	}
+	history.mu.Lock()
+	vs := history.m[zone]
+	v := &PlanVersion{Zone: zone, Version: 1, Reason: reason, Schedule: res.Schedule, Moves: res.Moves, At: at, Assignments: as}
+	var prev []Assignment
+	var prevAt time.Time
+	if n := len(vs); n > 0 {
+		v.Version = vs[n-1].Version + 1
+		prev, prevAt = vs[n-1].Assignments, vs[n-1].At
+	}
+	v.Changes = diffPlans(prev, as)
+	vs = append(vs, v)
+	if len(vs) > historyMemKeep {
+		vs = append([]*PlanVersion(nil), vs[len(vs)-historyMemKeep:]...)
+	}
+	history.m[zone] = vs
+	history.mu.Unlock()
+	accrueUsage(zone, prev, prevAt, at)
+	logger.MapAlloc().Printf("zone=%s plan version=%d reason=%s roles=%d changes=%d", zone, v.Version, reason, len(as), len(v.Changes))
+	enqueueHistory(v)
+	return *v
+}
+
+// historyQueueSize 待写库版本的队列长度；写库跟不上时丢弃新版本（只保留在内存）并记录日志
+const historyQueueSize = 1024
+
+// historyWriter 规划历史在后台协程中按记录顺序写库：RecordPlan 在区服规划锁内调用，
+// 慢库或断库不应阻塞规划与推送
+var historyWriter struct {
+	mu   sync.Mutex
+	ch   chan *PlanVersion
+	done chan struct{}
+}
+
+// StartHistoryWriter 启动规划历史写库协程。须在 LoadPlanHistory 成功之后调用：
+// 否则内存中的版本号从 1 重新开始，写入会与库中已有版本冲突。未启动时历史只保存在内存
+func StartHistoryWriter() {
+	historyWriter.mu.Lock()
+	defer historyWriter.mu.Unlock()
+	if historyWriter.ch != nil || db.DB() == nil {
+		return
+	}
+	ch := make(chan *PlanVersion, historyQueueSize)
+	done := make(chan struct{})
+	historyWriter.ch, historyWriter.done = ch, done
+	go func() {
+		defer close(done)
+		for v := range ch {
+			if err := saveHistory(v); err != nil {
+				logger.MapAlloc().Printf("zone=%s plan version=%d persist failed: %v", v.Zone, v.Version, err)
+			}
+		}
+	}()
+}
+
+// StopHistoryWriter 停止写库协程并写出队列中剩余的版本；ctx 到期时放弃等待
+func StopHistoryWriter(ctx context.Context) error {
+	historyWriter.mu.Lock()
+	ch, done := historyWriter.ch, historyWriter.done
+	historyWriter.ch = nil
+	historyWriter.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	close(ch)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueueHistory 版本入写库队列，不阻塞；版本记录后不再修改，可与内存历史共享
+func enqueueHistory(v *PlanVersion) {
+	historyWriter.mu.Lock()
+	defer historyWriter.mu.Unlock()
+	if historyWriter.ch == nil {
+		return
+	}
+	select {
+	case historyWriter.ch <- v:
+	default:
+		logger.MapAlloc().Printf("zone=%s plan version=%d not persisted: history queue full", v.Zone, v.Version)
+	}
+}
+
+// LatestPlanVersion 区服最近一次记录的版本号（没有为 0）
+func LatestPlanVersion(zone string) int {
+	history.mu.RLock()
+	defer history.mu.RUnlock()
+	if vs := history.m[zone]; len(vs) > 0 {
+		return vs[len(vs)-1].Version
+	}
+	return 0
+}
+
+func diffPlans(prev, cur []Assignment) []PlanChange {
+	before := make(map[string]MapTarget, len(prev))
+	for _, a := range prev {
+		before[a.RoleName] = a.Target
+	}
+	changes := []PlanChange{}
+	seen := make(map[string]bool, len(cur))
+	for _, a := range cur {
+		seen[a.RoleName] = true
+		to := a.Target
+		if from, ok := before[a.RoleName]; !ok {
+			changes = append(changes, PlanChange{Role: a.RoleName, To: &to})
+		} else if from != to {
+			changes = append(changes, PlanChange{Role: a.RoleName, From: &from, To: &to})
+		}
+	}
+	for _, a := range prev {
+		if !seen[a.RoleName] {
+			from := a.Target
+			changes = append(changes, PlanChange{Role: a.RoleName, From: &from})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Role < changes[j].Role })
+	return changes
+}
+
+// PlanAt 区服在 at 时刻生效的计划（at 之前最近的版本），没有时返回 nil
+func PlanAt(zone string, at time.Time) (*PlanVersion, error) {
+	if db.DB() == nil {
+		history.mu.RLock()
+		defer history.mu.RUnlock()
+		vs := history.m[zone]
+		i := sort.Search(len(vs), func(i int) bool { return vs[i].At.After(at) })
+		if i == 0 {
+			return nil, nil
+		}
+		v := *vs[i-1]
+		return &v, nil
+	}
+	var row historyRow
+	err := db.DB().Get(&row, `SELECT id, zone, version, reason, schedule, moves, changes, created_at
+		FROM plan_history WHERE zone=? AND created_at<=? ORDER BY version DESC LIMIT 1`, zone, at)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var allocs []allocationRow
+	if err := db.DB().Select(&allocs, `SELECT role_name, map_name, floor, branch
+		FROM map_allocations WHERE plan_id=? ORDER BY role_name`, row.ID); err != nil {
+		return nil, err
+	}
+	v := row.version()
+	for _, a := range allocs {
+		v.Assignments = append(v.Assignments, a.assignment())
+	}
+	return &v, nil
+}
+
+// ListPlanVersions 区服在 [since, until] 内的版本及变化（不含完整分配）
+func ListPlanVersions(zone string, since, until time.Time) ([]PlanVersion, error) {
+	out := []PlanVersion{}
+	if db.DB() == nil {
+		history.mu.RLock()
+		defer history.mu.RUnlock()
+		for _, v := range history.m[zone] {
+			if !v.At.Before(since) && !v.At.After(until) {
+				c := *v
+				c.Assignments = nil
+				out = append(out, c)
+			}
+		}
+		return out, nil
+	}
+	var rows []historyRow
+	if err := db.DB().Select(&rows, `SELECT id, zone, version, reason, schedule, moves, changes, created_at
+		FROM plan_history WHERE zone=? AND created_at BETWEEN ? AND ? ORDER BY version`, zone, since, until); err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		out = append(out, r.version())
+	}
+	return out, nil
+}
+
+// timelinePoint 某个版本中该角色的目标
+type timelinePoint struct {
+	zone    string
+	version int
+	reason  string
+	at      time.Time
+	target  *MapTarget
+	branch  string
+}
+
+// RoleTimeline 角色在 [since, until] 内的目标变化。zones 为角色可能所在的区服（合区后包含旧区服名），
+// 只有角色出现过的区服的后续版本才会记为“未分配”
+func RoleTimeline(zones []string, role string, since, until time.Time) ([]TimelineEntry, error) {
+	var points []timelinePoint
+	if db.DB() == nil {
+		history.mu.RLock()
+		for _, z := range zones {
+			vs := history.m[z]
+			// 从 since 时刻生效的版本开始
+			start := sort.Search(len(vs), func(i int) bool { return vs[i].At.After(since) })
+			if start > 0 {
+				start--
+			}
+			for _, v := range vs[start:] {
+				if v.At.After(until) {
+					break
+				}
+				pt := timelinePoint{zone: z, version: v.Version, reason: v.Reason, at: v.At}
+				for _, a := range v.Assignments {
+					if a.RoleName == role {
+						t := a.Target
+						pt.target = &t
+						if a.Reason != nil {
+							pt.branch = a.Reason.Branch
+						}
+						break
+					}
+				}
+				points = append(points, pt)
+			}
+		}
+		history.mu.RUnlock()
+		sort.SliceStable(points, func(i, j int) bool { return points[i].at.Before(points[j].at) })
+	} else {
+		in, args := db.InClause("zone", zones)
+		var start time.Time
+		if err := db.DB().Get(&start, `SELECT COALESCE(MAX(created_at), ?) FROM plan_history WHERE `+in+` AND created_at<=?`,
+			append(append([]any{since}, args...), since)...); err != nil {
+			return nil, err
+		}
+		hin, hargs := db.InClause("h.zone", zones)
+		var rows []struct {
+			Zone    string    `db:"zone"`
+			Version int       `db:"version"`
+			Reason  string    `db:"reason"`
+			At      time.Time `db:"created_at"`
+			Map     *string   `db:"map_name"`
+			Floor   *int      `db:"floor"`
+			Branch  *string   `db:"branch"`
+		}
+		q := `SELECT h.zone, h.version, h.reason, h.created_at, a.map_name, a.floor, a.branch
+			FROM plan_history h LEFT JOIN map_allocations a ON a.plan_id=h.id AND a.role_name=?
+			WHERE ` + hin + ` AND h.created_at BETWEEN ? AND ? ORDER BY h.created_at, h.id`
+		if err := db.DB().Select(&rows, q, append(append([]any{role}, hargs...), start, until)...); err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			pt := timelinePoint{zone: r.Zone, version: r.Version, reason: r.Reason, at: r.At}
+			if r.Map != nil {
+				pt.target = &MapTarget{Map: *r.Map, Floor: *r.Floor}
+				pt.branch = *r.Branch
+			}
+			points = append(points, pt)
+		}
+	}
+	return collapseTimeline(points), nil
+}
+
+// collapseTimeline 合并连续相同目标的版本；角色所在区服以最近一次被分配的区服为准
+func collapseTimeline(points []timelinePoint) []TimelineEntry {
+	out := []TimelineEntry{}
+	zone := ""
+	for _, pt := range points {
+		if pt.target == nil && pt.zone != zone {
+			continue
+		}
+		if pt.target != nil {
+			zone = pt.zone
+		}
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			if last.Zone == pt.zone && sameTarget(last.Target, pt.target) {
+				continue
+			}
+			at := pt.at
+			last.Until = &at
+		}
+		out = append(out, TimelineEntry{Zone: pt.zone, Version: pt.version, Reason: pt.reason, Target: pt.target, Branch: pt.branch, From: pt.at})
+	}
+	return out
+}
+
+func sameTarget(a, b *MapTarget) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+type historyRow struct {
+	ID       int64     `db:"id"`
+	Zone     string    `db:"zone"`
+	Version  int       `db:"version"`
+	Reason   string    `db:"reason"`
+	Schedule string    `db:"schedule"`
+	Moves    int       `db:"moves"`
+	Changes  []byte    `db:"changes"`
+	At       time.Time `db:"created_at"`
+}
+
+func (r historyRow) version() PlanVersion {
+	v := PlanVersion{Zone: r.Zone, Version: r.Version, Reason: r.Reason, Schedule: r.Schedule, Moves: r.Moves, At: r.At, Changes: []PlanChange{}}
+	_ = json.Unmarshal(r.Changes, &v.Changes)
+	return v
+}
+
+type allocationRow struct {
+	Role   string `db:"role_name"`
+	Map    string `db:"map_name"`
+	Floor  int    `db:"floor"`
+	Branch string `db:"branch"`
+}
+
+func (r allocationRow) assignment() Assignment {
+	a := Assignment{RoleName: r.Role, Target: MapTarget{Map: r.Map, Floor: r.Floor}}
+	if r.Branch != "" {
+		a.Reason = &Reason{Branch: r.Branch}
+	}
+	return a
+}
+
+func saveHistory(v *PlanVersion) error {
+	if db.DB() == nil {
+		return nil
+	}
+	changes, _ := json.Marshal(v.Changes)
+	return db.Tx(func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`INSERT INTO plan_history (zone, version, reason, schedule, moves, changes, created_at)
+			VALUES (?,?,?,?,?,?,?)`, v.Zone, v.Version, v.Reason, v.Schedule, v.Moves, changes, v.At)
+		if err != nil {
+			return err
+		}
+		if len(v.Assignments) == 0 {
+			return nil
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		args := make([]any, 0, len(v.Assignments)*7)
+		rows := make([]string, 0, len(v.Assignments))
+		for _, a := range v.Assignments {
+			branch := ""
+			if a.Reason != nil {
+				branch = a.Reason.Branch
+			}
+			rows = append(rows, "("+db.Placeholders(7)+")")
+			args = append(args, id, v.Zone, a.RoleName, a.Target.Map, a.Target.Floor, branch, v.At)
+		}
+		_, err = tx.Exec(`INSERT INTO map_allocations (plan_id, zone, role_name, map_name, floor, branch, created_at)
+			VALUES `+strings.Join(rows, ","), args...)
+		return err
+	})
+}
+
+// LoadPlanHistory 启动时迁移规划历史表结构，加载每个区服最近一个版本作为版本号与变化比较的起点，
+// 并重建公平轮换的累计时长
+func LoadPlanHistory() error {
+	if db.DB() == nil {
+		return nil
+	}
+	// 早期 schema 的 map_allocations 没有 plan_id，CREATE TABLE IF NOT EXISTS 不会改动已有表；
+	// 迁移脚本检测到旧表时改名为 map_allocations_legacy 后按新结构重建，可重复执行
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := db.ExecScript(ctx, migrations.PlanHistory); err != nil {
+		return fmt.Errorf("migrate plan history schema: %w", err)
+	}
+	var rows []historyRow
+	if err := db.DB().Select(&rows, `SELECT h.id, h.zone, h.version, h.reason, h.schedule, h.moves, h.changes, h.created_at
+		FROM plan_history h JOIN (SELECT zone, MAX(version) AS version FROM plan_history GROUP BY zone) l
+		ON h.zone=l.zone AND h.version=l.version`); err != nil {
+		return err
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	for _, r := range rows {
+		var allocs []allocationRow
+		if err := db.DB().Select(&allocs, `SELECT role_name, map_name, floor, branch
+			FROM map_allocations WHERE plan_id=? ORDER BY role_name`, r.ID); err != nil {
+			return err
+		}
+		v := r.version()
+		for _, a := range allocs {
+			v.Assignments = append(v.Assignments, a.assignment())
+		}
+		history.m[r.Zone] = []*PlanVersion{&v}
+	}
+	// 累计时长只影响公平轮换排序，加载失败不影响版本号，不阻止历史写库
+	if err := loadUsage(time.Now()); err != nil {
+		logger.MapAlloc().Printf("fairness usage load failed: %v", err)
+	}
+	return nil
+}
+
+// PruneHistory 删除超过保留期的历史（PLAN_HISTORY_RETENTION，0 表示一直保留）
+func PruneHistory(now time.Time) {
+	keep := historyRetention()
+	if keep <= 0 || db.DB() == nil {
+		return
+	}
+	cutoff := now.Add(-keep)
+	if _, err := db.DB().Exec(`DELETE FROM map_allocations WHERE created_at < ?`, cutoff); err != nil {
+		logger.MapAlloc().Printf("plan history prune failed: %v", err)
+		return
+	}
+	if _, err := db.DB().Exec(`DELETE FROM plan_history WHERE created_at < ?`, cutoff); err != nil {
+		logger.MapAlloc().Printf("plan history prune failed: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package alloc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"wgserver/internal/logger"
+	eq "wgserver/internal/services/equipment"
+	"wgserver/internal/services/roles"
+)
+
+// 地图进入条件：规则文件 requirements 段按地图描述等级、技能、幸运、职业与装备档次要求，
+// 所有分配路径都经由 entryCheck 判定，角色不会被派往无法进入的地图。
+
+type MapRequirement struct {
+	MinLevel     int      `json:"min_level,omitempty"`
+	MinSkill     int      `json:"min_skill,omitempty"`
+	MinLuck      int      `json:"min_luck,omitempty"`
+	Classes      []string `json:"classes,omitempty"`        // 为空不限职业
+	MinEquipRank int      `json:"min_equip_rank,omitempty"` // 需具备该强度及以上套装的四主体
+}
+
+// Rejection 一次被拒绝的进入判定
+type Rejection struct {
+	Role   string
+	Map    string
+	Reason string
+}
+
+// entryCheck 判定角色能否进入地图；不能进入时返回原因（同一次规划内缓存结果）
+func (p *planner) entryCheck(r *roles.RoleInfo, m string) (bool, string) {
+	key := r.RoleName + "|" + m
+	if reason, ok := p.entryCache[key]; ok {
+		return reason == "", reason
+	}
+	reason := p.requirementFailure(r, m)
+	if p.entryCache == nil {
+		p.entryCache = map[string]string{}
+	}
+	p.entryCache[key] = reason
+	if reason != "" {
+		p.rejections = append(p.rejections, Rejection{Role: r.RoleName, Map: m, Reason: reason})
+	}
+	return reason == "", reason
+}
+
+func (p *planner) canEnter(r *roles.RoleInfo, m string) bool {
+	ok, _ := p.entryCheck(r, m)
+	return ok
+}
+
+func (p *planner) requirementFailure(r *roles.RoleInfo, m string) string {
+	if why := p.overrides.exclusionFailure(r.RoleName, m); why != "" {
+		return why
+	}
+	req, ok := p.rules.Requirements[m]
+	if !ok {
+		return ""
+	}
+	var fails []string
+	if req.MinLevel > 0 && r.Level < req.MinLevel {
+		fails = append(fails, fmt.Sprintf("等级 %d<%d", r.Level, req.MinLevel))
+	}
+	if req.MinSkill > 0 && r.Skill < req.MinSkill {
+		fails = append(fails, fmt.Sprintf("技能 %d<%d", r.Skill, req.MinSkill))
+	}
+	if req.MinLuck > 0 && r.Lucky < req.MinLuck {
+		fails = append(fails, fmt.Sprintf("幸运 %d<%d", r.Lucky, req.MinLuck))
+	}
+	if len(req.Classes) > 0 && !containsString(req.Classes, r.Class) {
+		fails = append(fails, fmt.Sprintf("职业 %s 不在 %v", r.Class, req.Classes))
+	}
+	if req.MinEquipRank > 0 {
+		if rank := p.bestFourPieceRank(r); rank < req.MinEquipRank {
+			fails = append(fails, fmt.Sprintf("四主体强度 %d<%d", rank, req.MinEquipRank))
+		}
+	}
+	return strings.Join(fails, "，")
+}
+
+// bestFourPieceRank 已穿戴装备中，同套不同部位达到 ATierPieces 件的最高套装强度（没有为 0）
+func (p *planner) bestFourPieceRank(r *roles.RoleInfo) int { return bestFourPieceRank(p.rules, r) }
+
+func bestFourPieceRank(rl *Rules, r *roles.RoleInfo) int {
+	setPos := map[string]map[string]struct{}{}
+	for _, e := range r.Equipments {
+		setName, ok := itemToSet[e.Name]
+		if !ok {
+			continue
+		}
+		if setPos[setName] == nil {
+			setPos[setName] = map[string]struct{}{}
+		}
+		// 去重：相同位置只计一次（两个手镯/戒指视为同类位置）
+		setPos[setName][normalizePos(e.Slot)] = struct{}{}
+	}
+	best := 0
+	for setName, posSet := range setPos {
+		if len(posSet) >= rl.ATierPieces && eq.EquipmentRank[setName] > best {
+			best = eq.EquipmentRank[setName]
+		}
+	}
+	return best
+}
+
+// 反向索引：装备名 -> 套装名
+var itemToSet = func() map[string]string {
+	m := map[string]string{}
+	for setName, items := range eq.EquipmentSets {
+		for name := range items {
+			m[name] = setName
+		}
+	}
+	return m
+}()
+
+// logUnassigned 记录本次规划未分配到任何地图的角色及其进入限制
+func (p *planner) logUnassigned(zone string, zs *roles.ZoneState, as []Assignment) {
+	if len(as) >= len(zs.Roles) {
+		return
+	}
+	assigned := make(map[string]bool, len(as))
+	for _, a := range as {
+		assigned[a.RoleName] = true
+	}
+	blocked := map[string][]string{}
+	for _, rj := range p.rejections {
+		blocked[rj.Role] = append(blocked[rj.Role], rj.Map+"("+rj.Reason+")")
+	}
+	names := make([]string, 0, len(zs.Roles)-len(as))
+	for name := range zs.Roles {
+		if !assigned[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logger.MapAlloc().Printf("zone=%s unassigned role=%s blocked=%s", zone, name, strings.Join(blocked[name], ","))
+	}
+}
@@ -0,0 +1,242 @@
+package alloc
+
+import "wgserver/internal/services/roles"
+
+// optimal 规划方式：把角色与副本名额建模为最小费用指派问题（匈牙利算法）。
+// 名额与规则方式一致（法师固定层 + 当前合区状态/人数选出的配额表），
+// 每个 (角色, 名额) 的收益为 地图难度 × 角色综合分，不可进入的组合禁止指派。
+
+// OptimalWeights 角色综合分 = 各属性 × 权重之和
+type OptimalWeights struct {
+	Level int `json:"level"`
+	Luck  int `json:"luck"`
+	Magic int `json:"magic"` // 道术
+	Skill int `json:"skill"`
+	Equip int `json:"equip"` // 四主体套装强度（EquipmentRank）
+}
+
+const BranchOptimal = "optimal"
+
+// forbidden 不可指派的费用，远大于任何合法收益；seatBonus 使“多占正常名额”优先于总收益
+const (
+	forbidden int64 = 1 << 50
+	seatBonus int64 = 1 << 32
+)
+
+type seat struct {
+	target   MapTarget
+	mageOnly bool
+	branch   string
+}
+
+// quality 角色综合分（至少为 1，保证任何合法名额都优于不分配）
+func (p *planner) quality(r *roles.RoleInfo) int64 {
+	w := p.rules.Optimal
+	q := int64(w.Level*r.Level + w.Luck*r.Lucky + w.Magic*r.Magic + w.Skill*r.Skill + w.Equip*p.bestFourPieceRank(r))
+	if q < 1 {
+		q = 1
+	}
+	return q
+}
+
+// difficulty 地图难度：Maps 中的序号（从 1 开始）× 10 + 层数
+func (p *planner) difficulty(t MapTarget) int64 {
+	for i, m := range p.rules.Maps {
+		if m == t.Map {
+			return int64(i+1)*10 + int64(t.Floor)
+		}
+	}
+	return int64(t.Floor)
+}
+
+// objective 计划总收益，用于比较不同规划方式
+func (p *planner) objective(zs *roles.ZoneState, as []Assignment) int64 {
+	var sum int64
+	for _, a := range as {
+		if r := zs.Roles[a.RoleName]; r != nil {
+			sum += p.difficulty(a.Target) * p.quality(r)
+		}
+	}
+	return sum
+}
+
+// otherQuotas 非法师名额：与规则方式按同样的条件选配额表；
+// 同时返回名额用尽后的兜底地图（高->低），与规则方式的溢出处理对应
+func (p *planner) otherQuotas(others []*roles.RoleInfo, insufficient bool) (map[string]int, []string) {
+	if insufficient {
+		q := map[string]int{}
+		var overflow []string
+		for _, m := range p.merge.FallbackOrder {
+			if len(p.merge.Quotas) == 0 {
+				q[m] = len(others)
+				overflow = append(overflow, m)
+			} else if n, ok := p.merge.Quotas[m]; ok {
+				q[m] = n
+				overflow = append(overflow, m)
+			}
+		}
+		return p.quotas(q), overflow
+	}
+	switch p.merge.Strategy {
+	case StrategyUnmerged:
+		_, plan := p.unmergedQuotas(others)
+		return plan, append([]string{p.merge.Unmerged.OverflowMap}, p.rules.FillOrder...)
+	case StrategyMerge1:
+		g := p.merge.Merge1
+		q := copyQuotas(g.LowQuotas)
+		rest := others[:len(others)-min(g.LowCount, len(others))]
+		sixty := 0
+		for _, r := range rest {
+			if r.Level >= g.SixtyLevel {
+				sixty++
+			}
+		}
+		if tb := pickTable(g.SixtyTables, sixty); tb != nil {
+			for _, s := range tb.Slots {
+				q[s.Map] += s.Count
+			}
+			for m, n := range tb.Quotas {
+				q[m] += n
+			}
+		}
+		return p.quotas(q), nil
+	default:
+		return p.quotas(p.merge.Quotas), nil
+	}
+}
+
+// planOptimal 最小费用指派
+func (p *planner) planOptimal(zs *roles.ZoneState) []Assignment {
+	mages, others := p.splitByClass(zs)
+	insufficient := p.total < p.merge.Total
+	mageTargets, mageBranch := p.mageTargets(insufficient)
+
+	var seats []seat
+	for _, mt := range mageTargets {
+		seats = append(seats, seat{target: mt, mageOnly: true, branch: mageBranch})
+	}
+	// 配额按规则方式的人员划分计算：固定层放不下的法师并入其他职业
+	pool := others
+	if len(mages) > len(mageTargets) {
+		pool = append(append([]*roles.RoleInfo{}, mages[len(mageTargets):]...), others...)
+	}
+	quotas, overflow := p.otherQuotas(pool, insufficient)
+	for _, m := range p.rules.Maps {
+		for i := 0; i < quotas[m]; i++ {
+			seats = append(seats, seat{target: MapTarget{Map: m, Floor: 1}, branch: BranchOptimal})
+		}
+	}
+
+	rows := append(append([]*roles.RoleInfo{}, mages...), others...)
+	// 每个角色额外一个专属列：兜底地图（规则方式的溢出去向，第一张可进入的图）或不分配
+	spill := make([]string, len(rows))
+	for i, r := range rows {
+		for _, m := range overflow {
+			if p.canEnter(r, m) {
+				spill[i] = m
+				break
+			}
+		}
+	}
+	n := len(seats)
+	cost := make([][]int64, len(rows))
+	for i, r := range rows {
+		cost[i] = make([]int64, n+len(rows))
+		for j, s := range seats {
+			if (s.mageOnly && !isMage(r.Class)) || !p.canEnter(r, s.target.Map) {
+				cost[i][j] = forbidden
+				continue
+			}
+			bonus := seatBonus
+			if s.mageOnly {
+				// 与规则方式一致：法师先占满固定层
+				bonus *= 2
+			}
+			cost[i][j] = -bonus - p.difficulty(s.target)*p.quality(r)
+		}
+		for k := range rows {
+			switch {
+			case k != i:
+				cost[i][n+k] = forbidden
+			case spill[i] != "":
+				cost[i][n+k] = -p.difficulty(MapTarget{Map: spill[i], Floor: 1}) * p.quality(r)
+			}
+		}
+	}
+	match := hungarian(cost)
+
+	as := []Assignment{}
+	for i, j := range match {
+		switch {
+		case j < n && cost[i][j] < forbidden:
+			as = append(as, Assignment{RoleName: rows[i].RoleName, Target: seats[j].target, Reason: &Reason{Branch: seats[j].branch}})
+		case j == n+i && spill[i] != "":
+			as = append(as, Assignment{RoleName: rows[i].RoleName, Target: MapTarget{Map: spill[i], Floor: 1}, Reason: &Reason{Branch: BranchOverflow}})
+		}
+	}
+	return as
+}
+
+// hungarian 求解 n×m（n<=m）最小费用指派，返回每行匹配的列
+func hungarian(cost [][]int64) []int {
+	n := len(cost)
+	if n == 0 {
+		return nil
+	}
+	m := len(cost[0])
+	const inf = int64(1) << 62
+	u := make([]int64, n+1)
+	v := make([]int64, m+1)
+	way := make([]int, m+1)
+	match := make([]int, m+1) // 列 -> 行（1 起始，0 为空）
+	for i := 1; i <= n; i++ {
+		match[0] = i
+		j0 := 0
+		minv := make([]int64, m+1)
+		usedCol := make([]bool, m+1)
+		for j := range minv {
+			minv[j] = inf
+		}
+		for {
+			usedCol[j0] = true
+			i0, delta, j1 := match[j0], inf, 0
+			for j := 1; j <= m; j++ {
+				if usedCol[j] {
+					continue
+				}
+				if cur := cost[i0-1][j-1] - u[i0] - v[j]; cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if usedCol[j] {
+					u[match[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if match[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			match[j0] = match[j1]
+			j0 = j1
+		}
+	}
+	rowMatch := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if match[j] != 0 {
+			rowMatch[match[j]-1] = j - 1
+		}
+	}
+	return rowMatch
+}
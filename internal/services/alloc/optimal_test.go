@@ -0,0 +1,113 @@
+package alloc
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// bruteForce 枚举所有单射，返回最小总费用
+func bruteForce(cost [][]int64) int64 {
+	used := make([]bool, len(cost[0]))
+	var best int64 = 1 << 62
+	var walk func(i int, sum int64)
+	walk = func(i int, sum int64) {
+		if i == len(cost) {
+			best = min(best, sum)
+			return
+		}
+		for j := range used {
+			if !used[j] {
+				used[j] = true
+				walk(i+1, sum+cost[i][j])
+				used[j] = false
+			}
+		}
+	}
+	walk(0, 0)
+	return best
+}
+
+func TestHungarian(t *testing.T) {
+	F, B := forbidden, seatBonus
+	cases := []struct {
+		name string
+		cost [][]int64
+	}{
+		{"square", [][]int64{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}}},
+		{"more columns than rows", [][]int64{{7, 3, 9, 1, 8}, {2, 6, 4, 5, 3}, {9, 9, 1, 9, 2}}},
+		{"forbidden cells are avoided", [][]int64{{F, -5, F}, {-9, -8, F}, {F, F, -1}}},
+		// 与 planOptimal 相同的费用形态：占用名额的奖励压过总收益差距，专属兜底列收益更高也不选
+		{"seat bonus dominates objective", [][]int64{
+			{-B - 30, -B - 20, -300, F},
+			{-B - 40, -B - 10, F, -400},
+		}},
+		{"mage seats weigh double", [][]int64{
+			{-2*B - 10, -B - 90, -50, F},
+			{F, -B - 80, F, -60},
+		}},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for k := 0; k < 30; k++ {
+		n := 1 + rng.Intn(5)
+		m := n + rng.Intn(3)
+		cost := make([][]int64, n)
+		for i := range cost {
+			cost[i] = make([]int64, m)
+			for j := range cost[i] {
+				switch rng.Intn(6) {
+				case 0:
+					cost[i][j] = F
+				case 1:
+					cost[i][j] = -B - int64(rng.Intn(1000))
+				default:
+					cost[i][j] = -int64(rng.Intn(1000))
+				}
+			}
+		}
+		cases = append(cases, struct {
+			name string
+			cost [][]int64
+		}{fmt.Sprintf("random %d (%dx%d)", k, n, m), cost})
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			match := hungarian(tc.cost)
+			if len(match) != len(tc.cost) {
+				t.Fatalf("matched %d rows, want %d", len(match), len(tc.cost))
+			}
+			seen := map[int]bool{}
+			var sum int64
+			for i, j := range match {
+				if seen[j] {
+					t.Fatalf("column %d matched twice: %v", j, match)
+				}
+				seen[j] = true
+				sum += tc.cost[i][j]
+			}
+			if want := bruteForce(tc.cost); sum != want {
+				t.Errorf("total cost %d (match %v), brute force %d", sum, match, want)
+			}
+		})
+	}
+}
+
+// 两种规划方式在各合区状态满员随机区服上的完整规划耗时，objective 为计划总收益
+func BenchmarkPlanSnapshot(b *testing.B) {
+	for _, mode := range []string{PlannerRules, PlannerOptimal} {
+		for _, ms := range CurrentRules().MergeStates {
+			b.Run(mode+"/"+ms.Name, func(b *testing.B) {
+				usePlanner(b, mode)
+				zone := "基准" + ms.Name
+				zs := randomZone(rand.New(rand.NewSource(1)), zone, ms.Name, ms.Total)
+				var res PlanResult
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					res = PlanSnapshot(zone, zs, nil)
+				}
+				b.ReportMetric(float64(res.Objective), "objective")
+			})
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package alloc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"wgserver/internal/db"
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 人工干预：管理员按区服设置的固定分配（pin）与排除（exclusion），均有到期时间。
+// 规划时先放置 pin 并占用对应名额，排除项通过进入判定生效；到期后自动失效。
+
+const (
+	OverridePin     = "pin"
+	OverrideExclude = "exclude"
+)
+
+// DefaultOverrideTTL 未指定到期时间时的有效期
+const DefaultOverrideTTL = 24 * time.Hour
+
+// Override pin：Role + Target；exclude：只填 Role 表示该角色不参与规划，只填 Map 表示
+// 本区服关闭该地图，两者都填表示该角色不得进入该地图
+type Override struct {
+	Zone      string    `json:"zone" db:"zone"`
+	Kind      string    `json:"kind" db:"kind"`
+	Role      string    `json:"role,omitempty" db:"role_name"`
+	Map       string    `json:"map,omitempty" db:"map_name"`
+	Floor     int       `json:"floor,omitempty" db:"floor"`
+	Note      string    `json:"note,omitempty" db:"note"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (o *Override) key() string { return o.Kind + "|" + o.Role + "|" + o.Map }
+
+var overrides = struct {
+	mu sync.RWMutex
+	m  map[string]map[string]*Override // zone -> key -> override
+}{m: map[string]map[string]*Override{}}
+
+// SetOverride 新增或替换一条干预（同一区服、类型、角色、地图只保留一条）
+func SetOverride(o Override) (Override, error) {
+	switch o.Kind {
+	case OverridePin:
+		if o.Role == "" || o.Map == "" {
+			return o, errors.New("pin requires role and map")
+		}
+		if o.Floor < 1 {
+			o.Floor = 1
+		}
+	case OverrideExclude:
+		if o.Role == "" && o.Map == "" {
+			return o, errors.New("exclude requires role or map")
+		}
+		o.Floor = 0
+	default:
+		return o, fmt.Errorf("unknown kind %q", o.Kind)
+	}
+	if o.Zone == "" {
+		return o, errors.New("zone is required")
+	}
+	if o.Map != "" && !containsString(CurrentRules().Maps, o.Map) {
+		return o, fmt.Errorf("unknown map %q", o.Map)
+	}
+	now := time.Now()
+	o.CreatedAt = now
+	if o.ExpiresAt.IsZero() {
+		o.ExpiresAt = now.Add(DefaultOverrideTTL)
+	}
+	if !o.ExpiresAt.After(now) {
+		return o, errors.New("expires_at is in the past")
+	}
+	overrides.mu.Lock()
+	zm := overrides.m[o.Zone]
+	if zm == nil {
+		zm = map[string]*Override{}
+		overrides.m[o.Zone] = zm
+	}
+	// 同一角色只能有一个 pin
+	if o.Kind == OverridePin {
+		for k, e := range zm {
+			if e.Kind == OverridePin && e.Role == o.Role {
+				delete(zm, k)
+			}
+		}
+	}
+	cp := o
+	zm[o.key()] = &cp
+	overrides.mu.Unlock()
+	if err := saveOverride(o); err != nil {
+		logger.MapAlloc().Printf("override persist failed zone=%s kind=%s role=%s map=%s err=%v", o.Zone, o.Kind, o.Role, o.Map, err)
+	}
+	logger.MapAlloc().Printf("override set zone=%s kind=%s role=%s map=%s floor=%d expires=%s note=%s",
+		o.Zone, o.Kind, o.Role, o.Map, o.Floor, o.ExpiresAt.Format(time.RFC3339), o.Note)
+	return o, nil
+}
+
+// RemoveOverride 删除一条干预，返回是否存在
+func RemoveOverride(zone, kind, role, mapName string) bool {
+	o := Override{Zone: zone, Kind: kind, Role: role, Map: mapName}
+	overrides.mu.Lock()
+	zm := overrides.m[zone]
+	_, ok := zm[o.key()]
+	if ok {
+		delete(zm, o.key())
+	}
+	overrides.mu.Unlock()
+	if ok {
+		if err := deleteOverride(o); err != nil {
+			logger.MapAlloc().Printf("override delete failed zone=%s kind=%s role=%s map=%s err=%v", zone, kind, role, mapName, err)
+		}
+		logger.MapAlloc().Printf("override removed zone=%s kind=%s role=%s map=%s", zone, kind, role, mapName)
+	}
+	return ok
+}
+
+// ListOverrides 返回未过期的干预（zone 为空返回全部），按区服、类型、角色、地图排序
+func ListOverrides(zone string, now time.Time) []Override {
+	overrides.mu.RLock()
+	defer overrides.mu.RUnlock()
+	out := []Override{}
+	for z, zm := range overrides.m {
+		if zone != "" && z != zone {
+			continue
+		}
+		for _, o := range zm {
+			if o.ExpiresAt.After(now) {
+				out = append(out, *o)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		return a.Map < b.Map
+	})
+	return out
+}
+
+// ExpireOverrides 清除到期的干预，返回受影响的区服（调用方据此重新规划）
+func ExpireOverrides(now time.Time) []string {
+	overrides.mu.Lock()
+	var zones []string
+	var expired []Override
+	for z, zm := range overrides.m {
+		n := len(expired)
+		for k, o := range zm {
+			if !o.ExpiresAt.After(now) {
+				expired = append(expired, *o)
+				delete(zm, k)
+			}
+		}
+		if len(expired) > n {
+			zones = append(zones, z)
+		}
+		if len(zm) == 0 {
+			delete(overrides.m, z)
+		}
+	}
+	overrides.mu.Unlock()
+	for _, o := range expired {
+		logger.MapAlloc().Printf("override expired zone=%s kind=%s role=%s map=%s", o.Zone, o.Kind, o.Role, o.Map)
+		if err := deleteOverride(o); err != nil {
+			logger.MapAlloc().Printf("override delete failed zone=%s kind=%s role=%s map=%s err=%v", o.Zone, o.Kind, o.Role, o.Map, err)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// MergeOverrides 合区后将来源区服的干预迁移到目标区服（目标区已有的同键条目优先）
+func MergeOverrides(target string, sources []string) {
+	overrides.mu.Lock()
+	defer overrides.mu.Unlock()
+	for _, s := range sources {
+		sm := overrides.m[s]
+		if sm == nil {
+			continue
+		}
+		tm := overrides.m[target]
+		if tm == nil {
+			tm = map[string]*Override{}
+			overrides.m[target] = tm
+		}
+		for k, o := range sm {
+			if _, dup := tm[k]; dup {
+				logger.MapAlloc().Printf("override merge dropped zone=%s kind=%s role=%s map=%s: target zone %s has the same entry",
+					s, o.Kind, o.Role, o.Map, target)
+				continue
+			}
+			o.Zone = target
+			tm[k] = o
+		}
+		delete(overrides.m, s)
+	}
+	if db.DB() == nil {
+		return
+	}
+	q, args := db.InClause("zone", sources)
+	if _, err := db.DB().Exec(`UPDATE IGNORE plan_overrides SET zone=? WHERE `+q, append([]any{target}, args...)...); err != nil {
+		logger.MapAlloc().Printf("override merge persist failed zone=%s err=%v", target, err)
+		return
+	}
+	// UPDATE IGNORE 跳过与目标区同键的行，这些行在内存中已被目标区条目取代，直接删除
+	res, err := db.DB().Exec(`DELETE FROM plan_overrides WHERE `+q, args...)
+	if err != nil {
+		logger.MapAlloc().Printf("override merge cleanup failed zone=%s err=%v", target, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		logger.MapAlloc().Printf("override merge dropped %d conflicting rows zone=%s sources=%v", n, target, sources)
+	}
+}
+
+// LoadOverrides 启动时从数据库加载未过期的干预
+func LoadOverrides() error {
+	if db.DB() == nil {
+		return nil
+	}
+	var rows []Override
+	if err := db.DB().Select(&rows, `SELECT zone, kind, role_name, map_name, floor, note, expires_at, created_at
+		FROM plan_overrides WHERE expires_at > ?`, time.Now()); err != nil {
+		return err
+	}
+	overrides.mu.Lock()
+	defer overrides.mu.Unlock()
+	for i := range rows {
+		o := rows[i]
+		if overrides.m[o.Zone] == nil {
+			overrides.m[o.Zone] = map[string]*Override{}
+		}
+		overrides.m[o.Zone][o.key()] = &o
+	}
+	return nil
+}
+
+func saveOverride(o Override) error {
+	if db.DB() == nil {
+		return nil
+	}
+	_, err := db.DB().Exec(`INSERT INTO plan_overrides (zone, kind, role_name, map_name, floor, note, expires_at, created_at)
+		VALUES (?,?,?,?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE floor=VALUES(floor), note=VALUES(note), expires_at=VALUES(expires_at), created_at=VALUES(created_at)`,
+		o.Zone, o.Kind, o.Role, o.Map, o.Floor, o.Note, o.ExpiresAt, o.CreatedAt)
+	if err == nil && o.Kind == OverridePin {
+		_, err = db.DB().Exec(`DELETE FROM plan_overrides WHERE zone=? AND kind=? AND role_name=? AND map_name<>?`,
+			o.Zone, o.Kind, o.Role, o.Map)
+	}
+	return err
+}
+
+func deleteOverride(o Override) error {
+	if db.DB() == nil {
+		return nil
+	}
+	_, err := db.DB().Exec(`DELETE FROM plan_overrides WHERE zone=? AND kind=? AND role_name=? AND map_name=?`,
+		o.Zone, o.Kind, o.Role, o.Map)
+	return err
+}
+
+// zoneOverrides 规划时生效的干预
+type zoneOverrides struct {
+	pins        map[string]MapTarget // 角色 -> 目标
+	roleOut     map[string]bool      // 不参与规划的角色
+	mapOut      map[string]bool      // 关闭的地图
+	roleMapOut  map[string]bool      // role|map
+	reserved    map[string]int       // pin 占用的名额，规划各配额表时逐一扣减
+	mageTarget  map[MapTarget]bool   // pin 占用的法师固定层
+	pinReasons  map[string]string    // 被忽略的 pin 及原因
+	activeCount int
+}
+
+func activeOverrides(zone string, now time.Time) *zoneOverrides {
+	zo := &zoneOverrides{
+		pins:       map[string]MapTarget{},
+		roleOut:    map[string]bool{},
+		mapOut:     map[string]bool{},
+		roleMapOut: map[string]bool{},
+		reserved:   map[string]int{},
+		mageTarget: map[MapTarget]bool{},
+		pinReasons: map[string]string{},
+	}
+	overrides.mu.RLock()
+	defer overrides.mu.RUnlock()
+	for _, o := range overrides.m[zone] {
+		if !o.ExpiresAt.After(now) {
+			continue
+		}
+		zo.activeCount++
+		switch {
+		case o.Kind == OverridePin:
+			zo.pins[o.Role] = MapTarget{Map: o.Map, Floor: o.Floor}
+		case o.Role != "" && o.Map != "":
+			zo.roleMapOut[o.Role+"|"+o.Map] = true
+		case o.Role != "":
+			zo.roleOut[o.Role] = true
+		default:
+			zo.mapOut[o.Map] = true
+		}
+	}
+	return zo
+}
+
+// exclusionFailure 排除项导致的进入失败原因
+func (zo *zoneOverrides) exclusionFailure(role, m string) string {
+	if zo == nil {
+		return ""
+	}
+	if zo.mapOut[m] {
+		return "管理员关闭该地图"
+	}
+	if zo.roleMapOut[role+"|"+m] {
+		return "管理员排除"
+	}
+	return ""
+}
+
+// roleExcluded 角色是否被整体排除出规划
+func (zo *zoneOverrides) roleExcluded(role string) bool { return zo != nil && zo.roleOut[role] }
+
+// applyOverrides 放置 pin 并从候选中移除被 pin 或被排除的角色；返回 pin 分配与剩余角色
+func (p *planner) applyOverrides(zs *roles.ZoneState) ([]Assignment, *roles.ZoneState) {
+	zo := p.overrides
+	if zo == nil || zo.activeCount == 0 {
+		return nil, zs
+	}
+	rest := &roles.ZoneState{Roles: make(map[string]*roles.RoleInfo, len(zs.Roles)), ClientByRole: zs.ClientByRole,
+		LastUpdate: zs.LastUpdate, WaitAllocUntil: zs.WaitAllocUntil, Version: zs.Version}
+	var pinned []Assignment
+	names := make([]string, 0, len(zs.Roles))
+	for name := range zs.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := zs.Roles[name]
+		if zo.roleOut[name] {
+			p.rejections = append(p.rejections, Rejection{Role: name, Map: "*", Reason: "管理员排除，不参与规划"})
+			continue
+		}
+		if t, ok := zo.pins[name]; ok {
+			if ok, why := p.entryCheck(r, t.Map); !ok {
+				zo.pinReasons[name] = why
+			} else {
+				pinned = append(pinned, Assignment{RoleName: name, Target: t, Reason: &Reason{Branch: BranchPinned}})
+				if isMage(r.Class) && p.isMageTarget(t) {
+					zo.mageTarget[t] = true
+				} else {
+					zo.reserved[t.Map]++
+				}
+				continue
+			}
+		}
+		rest.Roles[name] = r
+	}
+	return pinned, rest
+}
+
+func (p *planner) isMageTarget(t MapTarget) bool {
+	for _, mt := range append(append([]MapTarget{}, p.merge.MageFixed...), p.merge.MageInsufficient...) {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// quotas 拷贝配额表并扣减 pin 已占用的名额（每个名额只扣一次）
+func (p *planner) quotas(q map[string]int) map[string]int {
+	out := copyQuotas(q)
+	if p.overrides == nil {
+		return out
+	}
+	for m, n := range out {
+		if r := p.overrides.reserved[m]; r > 0 {
+			d := min(r, n)
+			out[m] = n - d
+			p.overrides.reserved[m] = r - d
+		}
+	}
+	return out
+}
+
+// seatCount 扣减 pin 占用后的固定名额数
+func (p *planner) seatCount(m string, n int) int {
+	if p.overrides == nil {
+		return n
+	}
+	if r := p.overrides.reserved[m]; r > 0 {
+		d := min(r, n)
+		p.overrides.reserved[m] = r - d
+		return n - d
+	}
+	return n
+}
+
+// freeMageTargets 去掉已被 pin 占用的法师固定层
+func (p *planner) freeMageTargets(targets []MapTarget) []MapTarget {
+	if p.overrides == nil || len(p.overrides.mageTarget) == 0 {
+		return targets
+	}
+	out := make([]MapTarget, 0, len(targets))
+	for _, t := range targets {
+		if !p.overrides.mageTarget[t] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
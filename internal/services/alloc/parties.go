@@ -0,0 +1,140 @@
+package alloc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 组队：规则 parties 段标记的组队地图，在个人分配（含层数与法师固定层）完成后把同一地图同一层的角色
+// 按人数与职业配比编成队伍并指定队长；队伍随分配一起推送，客户端据此组队。
+// 不同层的角色无法组队，队伍只在层内编成，组队不改变各成员的层数。
+
+// PartyRule 组队地图的队伍配置
+type PartyRule struct {
+	Size        int            `json:"size"`                   // 每队人数
+	Classes     map[string]int `json:"classes,omitempty"`      // 职业 -> 每队人数，如 {"战士":1,"道士":1,"法师":1}；其余名额不限职业
+	LeaderClass string         `json:"leader_class,omitempty"` // 优先由该职业中最强者担任队长，为空时取队内最强者
+}
+
+// Party 一支队伍（同队成员的 Assignment 共用同一个 *Party）
+type Party struct {
+	ID      string   `json:"id"` // 地图-层-序号
+	Leader  string   `json:"leader"`
+	Members []string `json:"members"`           // 队长在前，其余按强度
+	Missing []string `json:"missing,omitempty"` // 配比中缺少的职业
+}
+
+// String 队长与成员的单行文本，用于比较队伍是否变化
+func (pt *Party) String() string {
+	if pt == nil {
+		return ""
+	}
+	return pt.ID + ":" + strings.Join(pt.Members, ",")
+}
+
+// formParties 为组队地图的角色按层编队：每队先按配比取各职业最强者，再由剩余最强者补满人数
+func (p *planner) formParties(zone string, zs *roles.ZoneState, as []Assignment) {
+	for i := range as {
+		as[i].Party = nil
+	}
+	if len(p.rules.Parties) == 0 {
+		return
+	}
+	byTarget := map[MapTarget][]int{}
+	var targets []MapTarget
+	for i, a := range as {
+		if _, ok := p.rules.Parties[a.Target.Map]; ok && zs.Roles[a.RoleName] != nil {
+			if byTarget[a.Target] == nil {
+				targets = append(targets, a.Target)
+			}
+			byTarget[a.Target] = append(byTarget[a.Target], i)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Map != targets[j].Map {
+			return targets[i].Map < targets[j].Map
+		}
+		return targets[i].Floor < targets[j].Floor
+	})
+	for _, tgt := range targets {
+		rule := p.rules.Parties[tgt.Map]
+		idx := byTarget[tgt]
+		sort.Slice(idx, func(i, j int) bool { return p.stronger(zs.Roles[as[idx[i]].RoleName], zs.Roles[as[idx[j]].RoleName]) })
+		left := idx
+		for n := 1; len(left) > 0; n++ {
+			var members []int
+			take := func(k int) {
+				members = append(members, left[k])
+				left = append(left[:k:k], left[k+1:]...)
+			}
+			party := &Party{ID: fmt.Sprintf("%s-%d-%d", tgt.Map, tgt.Floor, n)}
+			for _, class := range sortedKeys(rule.Classes) {
+				for c := 0; c < rule.Classes[class] && len(members) < rule.Size; c++ {
+					k := -1
+					for j, i := range left {
+						if zs.Roles[as[i].RoleName].Class == class {
+							k = j
+							break
+						}
+					}
+					if k < 0 {
+						party.Missing = append(party.Missing, class)
+						continue
+					}
+					take(k)
+				}
+			}
+			for len(members) < rule.Size && len(left) > 0 {
+				take(0)
+			}
+			sort.Slice(members, func(i, j int) bool {
+				return p.stronger(zs.Roles[as[members[i]].RoleName], zs.Roles[as[members[j]].RoleName])
+			})
+			leader := 0
+			if rule.LeaderClass != "" {
+				for k, i := range members {
+					if zs.Roles[as[i].RoleName].Class == rule.LeaderClass {
+						leader = k
+						break
+					}
+				}
+			}
+			members = append([]int{members[leader]}, append(members[:leader:leader], members[leader+1:]...)...)
+			party.Leader = as[members[0]].RoleName
+			for _, i := range members {
+				party.Members = append(party.Members, as[i].RoleName)
+				as[i].Party = party
+			}
+			logger.MapAlloc().Printf("zone=%s party %s leader=%s members=%s missing=%v",
+				zone, party.ID, party.Leader, strings.Join(party.Members, ","), party.Missing)
+		}
+	}
+}
+
+func validateParties(parties map[string]PartyRule, known map[string]bool) []error {
+	var errs []error
+	for _, m := range sortedKeys(parties) {
+		rule := parties[m]
+		if !known[m] {
+			errs = append(errs, fmt.Errorf("parties: unknown map %q", m))
+		}
+		if rule.Size < 1 {
+			errs = append(errs, fmt.Errorf("parties.%s: size must be positive", m))
+		}
+		sum := 0
+		for c, n := range rule.Classes {
+			if c == "" || n < 0 {
+				errs = append(errs, fmt.Errorf("parties.%s: bad class count %q=%d", m, c, n))
+			}
+			sum += n
+		}
+		if sum > rule.Size {
+			errs = append(errs, fmt.Errorf("parties.%s: classes need %d members, size %d", m, sum, rule.Size))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,141 @@
+package alloc
+
+import (
+	"reflect"
+	"testing"
+
+	"wgserver/internal/services/roles"
+)
+
+// partyMember 法师、道士按 magic 比较强度；默认规则下战士不计道术，testRole 给出的战士强度相同（按角色名排序），相当于 magic 1200
+type partyMember struct {
+	name, class  string
+	magic, floor int
+}
+
+// partyPlan 在 地下魔域 上按给定层数放置角色并编队，返回 队伍编号 -> 成员（队长在前）与缺少的职业
+func partyPlan(rule PartyRule, members []partyMember) (map[string][]string, map[string][]string) {
+	p := newPlanner("七合", testNow)
+	rl := *p.rules
+	rl.Parties = map[string]PartyRule{"地下魔域": rule}
+	p.rules = &rl
+	zs := &roles.ZoneState{Roles: map[string]*roles.RoleInfo{}, ClientByRole: map[string]string{}}
+	var as []Assignment
+	for _, m := range members {
+		zs.Roles[m.name] = testRole(m.name, m.class, 65, 100, 0, m.magic)
+		as = append(as, Assignment{RoleName: m.name, Target: MapTarget{Map: "地下魔域", Floor: m.floor}})
+	}
+	p.formParties("测试区", zs, as)
+	got, missing := map[string][]string{}, map[string][]string{}
+	for _, a := range as {
+		if a.Party == nil {
+			continue
+		}
+		got[a.Party.ID] = a.Party.Members
+		if len(a.Party.Missing) > 0 {
+			missing[a.Party.ID] = a.Party.Missing
+		}
+		if a.Party.Leader != a.Party.Members[0] {
+			panic("leader must be listed first")
+		}
+	}
+	return got, missing
+}
+
+func TestFormParties(t *testing.T) {
+	trio := PartyRule{Size: 3, Classes: map[string]int{"战士": 1, "道士": 1, "法师": 1}, LeaderClass: "道士"}
+	cases := []struct {
+		name    string
+		rule    PartyRule
+		members []partyMember
+		parties map[string][]string
+		missing map[string][]string
+	}{
+		{
+			name: "class mix takes the strongest of each class, taoist leads",
+			rule: trio,
+			members: []partyMember{
+				{"w1", "战士", 900, 1}, {"w2", "战士", 800, 1}, {"w3", "战士", 700, 1},
+				{"t1", "道士", 600, 1}, {"t2", "道士", 500, 1},
+				{"m1", "法师", 400, 1}, {"m2", "法师", 300, 1},
+			},
+			parties: map[string][]string{
+				"地下魔域-1-1": {"t1", "w1", "m1"},
+				"地下魔域-1-2": {"t2", "w2", "m2"},
+				"地下魔域-1-3": {"w3"},
+			},
+			missing: map[string][]string{"地下魔域-1-3": {"法师", "道士"}},
+		},
+		{
+			name: "missing class is filled by the strongest remaining role",
+			rule: trio,
+			members: []partyMember{
+				{"w1", "战士", 900, 1}, {"w2", "战士", 800, 1}, {"t1", "道士", 600, 1}, {"t2", "道士", 100, 1},
+			},
+			parties: map[string][]string{
+				"地下魔域-1-1": {"t1", "w1", "w2"},
+				"地下魔域-1-2": {"t2"},
+			},
+			missing: map[string][]string{"地下魔域-1-1": {"法师"}, "地下魔域-1-2": {"战士", "法师"}},
+		},
+		{
+			name: "leader falls back to the strongest member without the leader class",
+			rule: trio,
+			members: []partyMember{
+				{"w1", "战士", 500, 1}, {"m1", "法师", 2000, 1}, {"w2", "战士", 300, 1},
+			},
+			parties: map[string][]string{"地下魔域-1-1": {"m1", "w1", "w2"}},
+			missing: map[string][]string{"地下魔域-1-1": {"道士"}},
+		},
+		{
+			name: "no leader class: strongest member leads, order by strength",
+			rule: PartyRule{Size: 2},
+			members: []partyMember{
+				{"a", "道士", 100, 1}, {"b", "战士", 300, 1}, {"c", "法师", 200, 1},
+			},
+			parties: map[string][]string{"地下魔域-1-1": {"b", "c"}, "地下魔域-1-2": {"a"}},
+			missing: map[string][]string{},
+		},
+		{
+			name: "roles on different floors never share a party",
+			rule: trio,
+			members: []partyMember{
+				{"w1", "战士", 900, 1}, {"t1", "道士", 600, 1}, {"m1", "法师", 400, 2}, {"m2", "法师", 300, 1},
+			},
+			parties: map[string][]string{
+				"地下魔域-1-1": {"t1", "w1", "m2"},
+				"地下魔域-2-1": {"m1"},
+			},
+			missing: map[string][]string{"地下魔域-2-1": {"战士", "道士"}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			parties, missing := partyPlan(tc.rule, tc.members)
+			if !reflect.DeepEqual(parties, tc.parties) {
+				t.Errorf("parties = %v, want %v", parties, tc.parties)
+			}
+			if !reflect.DeepEqual(missing, tc.missing) {
+				t.Errorf("missing = %v, want %v", missing, tc.missing)
+			}
+		})
+	}
+}
+
+func TestValidateParties(t *testing.T) {
+	known := map[string]bool{"地下魔域": true}
+	ok := map[string]PartyRule{"地下魔域": {Size: 3, Classes: map[string]int{"战士": 1, "道士": 1, "法师": 1}}}
+	if errs := validateParties(ok, known); len(errs) > 0 {
+		t.Fatalf("valid rule rejected: %v", errs)
+	}
+	for name, parties := range map[string]map[string]PartyRule{
+		"unknown map":     {"天宫": {Size: 3}},
+		"zero size":       {"地下魔域": {Size: 0}},
+		"classes overrun": {"地下魔域": {Size: 2, Classes: map[string]int{"战士": 2, "道士": 1}}},
+		"negative count":  {"地下魔域": {Size: 2, Classes: map[string]int{"战士": -1}}},
+	} {
+		if errs := validateParties(parties, known); len(errs) == 0 {
+			t.Errorf("%s: rule accepted", name)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package alloc
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 副本规划规则：合区状态、各图配额、法师固定层、兜底顺序与各类门槛均来自规则文件，
+// 内置默认规则见 default_rules.json；可通过 MAP_RULES_FILE 指定外部文件并热加载。
+
+//go:embed default_rules.json
+var defaultRulesJSON []byte
+
+type Rules struct {
+	Version       int                        `json:"version"`
+	Maps          []string                   `json:"maps"`         // 全部地图，难度由低到高
+	FillOrder     []string                   `json:"fill_order"`   // 通用分配时的地图优先级（高->低）
+	Requirements  map[string]MapRequirement  `json:"requirements"` // 地图 -> 进入条件，见 maps.go
+	LuckHigh      int                        `json:"luck_high"`
+	SkillHigh     int                        `json:"skill_high"`
+	ATierRank     int                        `json:"a_tier_rank"`    // “天尊或以上”套装强度下限
+	ATierPieces   int                        `json:"a_tier_pieces"`  // 同套不同部位件数下限
+	StabilityGain int                        `json:"stability_gain"` // 强度差不超过该值时保留角色上一轮的目标
+	DefaultMerge  string                     `json:"default_merge_state"`
+	MergeStates   []MergeRule                `json:"merge_states"`
+	Planner       string                     `json:"planner"`       // 默认规划方式：rules / optimal
+	ZonePlanners  map[string]string          `json:"zone_planners"` // 区服 -> 规划方式，覆盖默认
+	Optimal       OptimalWeights             `json:"optimal"`       // optimal 模式的打分权重，见 optimal.go
+	Floors        map[string][]FloorCapacity `json:"floors"`        // 地图 -> 各层容量，见 floors.go
+	Schedules     []Schedule                 `json:"schedules"`     // 时段规则，见 schedule.go
+	Scoring       ScoringRules               `json:"scoring"`       // 角色强度评分，见 scorer.go
+	Fairness      FairnessRules              `json:"fairness"`      // 公平轮换，见 fairness.go
+	Parties       map[string]PartyRule       `json:"parties"`       // 组队地图 -> 队伍配置，见 parties.go
+}
+
+type MergeRule struct {
+	Name             string         `json:"name"`
+	Aliases          []string       `json:"aliases,omitempty"`
+	Match            []string       `json:"match,omitempty"` // 子串匹配，别名都不命中时使用
+	Total            int            `json:"total"`           // 满员人数，同时作为首次规划的人数阈值
+	Strategy         string         `json:"strategy"`        // unmerged / merge1 / fixed
+	MageFixed        []MapTarget    `json:"mage_fixed"`
+	MageInsufficient []MapTarget    `json:"mage_insufficient"`
+	Quotas           map[string]int `json:"quotas"`
+	FallbackOrder    []string       `json:"fallback_order"` // 人数不足时其他职业的地图优先级（高->低）
+	Unmerged         *UnmergedRule  `json:"unmerged,omitempty"`
+	Merge1           *Merge1Rule    `json:"merge1,omitempty"`
+}
+
+// CountTable 按某个人数区间 [Min, Max] 选用的配额表；Max 为 -1 表示不设上限
+type CountTable struct {
+	Min    int            `json:"min"`
+	Max    int            `json:"max"`
+	Slots  []SeatCount    `json:"slots,omitempty"`
+	Quotas map[string]int `json:"quotas"`
+}
+
+type SeatCount struct {
+	Map   string `json:"map"`
+	Count int    `json:"count"`
+}
+
+type UnmergedRule struct {
+	EligibleMap        string       `json:"eligible_map"`    // 优先给 A 级四主体角色的地图
+	EligibleTables     []CountTable `json:"eligible_tables"` // 按 A 级四主体人数
+	SkillAfterEligible int          `json:"skill_after_eligible"`
+	SkillTables        []CountTable `json:"skill_tables"` // 按技能达标人数
+	PriorityMaps       []string     `json:"priority_maps"`
+	UpgradeMaps        []string     `json:"upgrade_maps"` // 技能达标角色按升级顺序优先进入的地图，先于 EligibleMap 分配，见 assignOthers_Unmerged
+	FillOrder          []string     `json:"fill_order"`
+	OverflowMap        string       `json:"overflow_map"`
+}
+
+type Merge1Rule struct {
+	LowCount    int            `json:"low_count"`
+	LowQuotas   map[string]int `json:"low_quotas"`
+	SixtyLevel  int            `json:"sixty_level"`
+	SixtyTables []CountTable   `json:"sixty_tables"` // 按 60 级人数；Slots 依强度顺序分配
+}
+
+const (
+	StrategyUnmerged = "unmerged"
+	StrategyMerge1   = "merge1"
+	StrategyFixed    = "fixed"
+)
+
+const (
+	PlannerRules   = "rules"   // 逐级贪心规则
+	PlannerOptimal = "optimal" // 最小费用指派
+)
+
+var currentRules atomic.Pointer[Rules]
+
+func init() {
+	rl, err := ParseRules(defaultRulesJSON)
+	if err != nil {
+		panic("alloc: invalid default rules: " + err.Error())
+	}
+	// 内置规则同样经 applyRules 生效，角色管理器的门槛列表与规则文件加载时一致
+	applyRules(rl)
+}
+
+// CurrentRules 返回当前生效的规则（只读）
+func CurrentRules() *Rules { return currentRules.Load() }
+
+// ParseRules 解析并校验规则
+func ParseRules(b []byte) (*Rules, error) {
+	var rl Rules
+	if err := json.Unmarshal(b, &rl); err != nil {
+		return nil, err
+	}
+	// 未配置评分权重的旧规则文件沿用原强度公式
+	if rl.Scoring.Default == (ScoreWeights{}) {
+		rl.Scoring.Default = LegacyScoreWeights
+	}
+	if err := rl.Validate(); err != nil {
+		return nil, err
+	}
+	return &rl, nil
+}
+
+// LoadRules 从文件加载规则，校验通过后原子替换当前规则
+func LoadRules(path string) (*Rules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rl, err := ParseRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	applyRules(rl)
+	logger.MapAlloc().Printf("map rules loaded from %s version=%d", path, rl.Version)
+	return rl, nil
+}
+
+func applyRules(rl *Rules) {
+	currentRules.Store(rl)
+	// 进入条件与各档门槛都需要在跨越时触发重新规划
+	var levels, skills, lucks []int
+	add := func(list []int, v int) []int {
+		if v <= 0 {
+			return list
+		}
+		for _, x := range list {
+			if x == v {
+				return list
+			}
+		}
+		return append(list, v)
+	}
+	skills = add(skills, rl.SkillHigh)
+	lucks = add(lucks, rl.LuckHigh)
+	for _, req := range rl.Requirements {
+		levels = add(levels, req.MinLevel)
+		skills = add(skills, req.MinSkill)
+		lucks = add(lucks, req.MinLuck)
+	}
+	levels = add(levels, rl.Scoring.Default.LevelBonusAt)
+	for _, w := range rl.Scoring.Classes {
+		levels = add(levels, w.LevelBonusAt)
+	}
+	for _, ms := range rl.MergeStates {
+		if ms.Merge1 != nil {
+			levels = add(levels, ms.Merge1.SixtyLevel)
+		}
+	}
+	roles.Instance().SetThresholds(levels, skills, lucks)
+}
+
+// WatchRules 按 interval 轮询文件修改时间，变化后重新加载；校验失败保留旧规则。
+// onChange 在新规则生效后调用（用于触发重新规划）。
+func WatchRules(path string, interval time.Duration, onChange func(*Rules)) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	var last time.Time
+	if st, err := os.Stat(path); err == nil {
+		last = st.ModTime()
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		st, err := os.Stat(path)
+		if err != nil || !st.ModTime().After(last) {
+			continue
+		}
+		last = st.ModTime()
+		rl, err := LoadRules(path)
+		if err != nil {
+			logger.MapAlloc().Printf("map rules reload rejected: %v", err)
+			continue
+		}
+		if onChange != nil {
+			onChange(rl)
+		}
+	}
+}
+
+// Merge 按合区状态查找规则：名称/别名精确匹配优先，其次子串匹配，否则使用默认
+func (rl *Rules) Merge(state string) *MergeRule {
+	for i := range rl.MergeStates {
+		ms := &rl.MergeStates[i]
+		if ms.Name == state || containsString(ms.Aliases, state) {
+			return ms
+		}
+	}
+	for i := range rl.MergeStates {
+		ms := &rl.MergeStates[i]
+		for _, sub := range ms.Match {
+			if sub != "" && strings.Contains(state, sub) {
+				return ms
+			}
+		}
+	}
+	for i := range rl.MergeStates {
+		if rl.MergeStates[i].Name == rl.DefaultMerge {
+			return &rl.MergeStates[i]
+		}
+	}
+	return &rl.MergeStates[0]
+}
+
+// PlannerFor 返回区服使用的规划方式
+func (rl *Rules) PlannerFor(zone string) string {
+	if mode, ok := rl.ZonePlanners[zone]; ok {
+		return mode
+	}
+	if rl.Planner == "" {
+		return PlannerRules
+	}
+	return rl.Planner
+}
+
+// NeededByMerge 首次规划所需的人数阈值
+func NeededByMerge(state string) int { return CurrentRules().Merge(state).Total }
+
+// Validate 检查地图名、数量与各配额表的人数是否与满员人数一致
+func (rl *Rules) Validate() error {
+	if rl.Version <= 0 {
+		return errors.New("version must be positive")
+	}
+	if len(rl.MergeStates) == 0 {
+		return errors.New("merge_states is empty")
+	}
+	known := map[string]bool{}
+	for _, m := range rl.Maps {
+		known[m] = true
+	}
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	checkMaps := func(where string, maps []string) {
+		for _, m := range maps {
+			if !known[m] {
+				bad("%s: unknown map %q", where, m)
+			}
+		}
+	}
+	checkQuotas := func(where string, q map[string]int) int {
+		sum := 0
+		for m, n := range q {
+			if !known[m] {
+				bad("%s: unknown map %q", where, m)
+			}
+			if n < 0 {
+				bad("%s: negative quota for %s", where, m)
+			}
+			sum += n
+		}
+		return sum
+	}
+	checkTables := func(where string, tables []CountTable, seats int) {
+		for i, tb := range tables {
+			w := fmt.Sprintf("%s[%d]", where, i)
+			if tb.Max >= 0 && tb.Max < tb.Min {
+				bad("%s: max %d < min %d", w, tb.Max, tb.Min)
+			}
+			sum := checkQuotas(w, tb.Quotas)
+			for _, s := range tb.Slots {
+				checkMaps(w, []string{s.Map})
+				sum += s.Count
+			}
+			if seats >= 0 && sum != seats {
+				bad("%s: %d seats, want %d", w, sum, seats)
+			}
+		}
+	}
+
+	checkMaps("fill_order", rl.FillOrder)
+	for m, req := range rl.Requirements {
+		checkMaps("requirements", []string{m})
+		if req.MinLevel < 0 || req.MinSkill < 0 || req.MinLuck < 0 || req.MinEquipRank < 0 {
+			bad("requirements.%s: negative minimum", m)
+		}
+	}
+	if rl.LuckHigh <= 0 || rl.SkillHigh <= 0 || rl.ATierRank <= 0 || rl.ATierPieces <= 0 {
+		bad("luck_high, skill_high, a_tier_rank and a_tier_pieces must be positive")
+	}
+	if rl.StabilityGain < 0 {
+		bad("stability_gain must not be negative")
+	}
+	for m, floors := range rl.Floors {
+		checkMaps("floors", []string{m})
+		if len(floors) == 0 {
+			bad("floors.%s: no floors", m)
+		}
+		seen := map[int]bool{}
+		for _, fc := range floors {
+			if fc.Floor < 1 || fc.Capacity < 0 || fc.MinLevel < 0 || seen[fc.Floor] {
+				bad("floors.%s: bad or duplicate floor %d", m, fc.Floor)
+			}
+			seen[fc.Floor] = true
+		}
+	}
+	validMode := func(mode string) bool { return mode == PlannerRules || mode == PlannerOptimal }
+	if rl.Planner != "" && !validMode(rl.Planner) {
+		bad("unknown planner %q", rl.Planner)
+	}
+	for z, mode := range rl.ZonePlanners {
+		if !validMode(mode) {
+			bad("zone_planners.%s: unknown planner %q", z, mode)
+		}
+	}
+	names := map[string]bool{}
+	for _, ms := range rl.MergeStates {
+		w := "merge_states." + ms.Name
+		if ms.Name == "" || names[ms.Name] {
+			bad("%s: empty or duplicate name", w)
+		}
+		names[ms.Name] = true
+		for _, mt := range append(append([]MapTarget{}, ms.MageFixed...), ms.MageInsufficient...) {
+			checkMaps(w+".mage", []string{mt.Map})
+			if mt.Floor < 1 {
+				bad("%s: mage floor for %s must be >= 1", w, mt.Map)
+			}
+		}
+		checkMaps(w+".fallback_order", ms.FallbackOrder)
+		others := ms.Total - len(ms.MageFixed)
+		quotaSum := checkQuotas(w+".quotas", ms.Quotas)
+		switch ms.Strategy {
+		case StrategyFixed:
+			if quotaSum != others {
+				bad("%s: quotas sum %d, want %d", w, quotaSum, others)
+			}
+		case StrategyUnmerged:
+			u := ms.Unmerged
+			if u == nil {
+				bad("%s: strategy unmerged requires unmerged section", w)
+				continue
+			}
+			if quotaSum != others {
+				bad("%s: quotas sum %d, want %d", w, quotaSum, others)
+			}
+			checkMaps(w+".unmerged", append(append([]string{u.EligibleMap, u.OverflowMap}, u.PriorityMaps...), u.FillOrder...))
+			checkMaps(w+".upgrade_maps", u.UpgradeMaps)
+			checkTables(w+".eligible_tables", u.EligibleTables, others)
+			checkTables(w+".skill_tables", u.SkillTables, others)
+			// 每个人数都要有表：四主体 0..others，技能达标 1..others
+			for n := 0; n <= others; n++ {
+				if pickTable(u.EligibleTables, n) == nil {
+					bad("%s.eligible_tables: no table for %d", w, n)
+				}
+				if n > 0 && len(u.SkillTables) > 0 && pickTable(u.SkillTables, n) == nil {
+					bad("%s.skill_tables: no table for %d", w, n)
+				}
+			}
+		case StrategyMerge1:
+			g := ms.Merge1
+			if g == nil {
+				bad("%s: strategy merge1 requires merge1 section", w)
+				continue
+			}
+			low := checkQuotas(w+".low_quotas", g.LowQuotas)
+			if low != g.LowCount {
+				bad("%s: low_quotas sum %d, want low_count %d", w, low, g.LowCount)
+			}
+			checkTables(w+".sixty_tables", g.SixtyTables, others-g.LowCount)
+		default:
+			bad("%s: unknown strategy %q", w, ms.Strategy)
+		}
+	}
+	if !names[rl.DefaultMerge] {
+		bad("default_merge_state %q not defined", rl.DefaultMerge)
+	}
+	errs = append(errs, validateScoring(rl.Scoring)...)
+	errs = append(errs, rl.Fairness.validate(known)...)
+	errs = append(errs, validateParties(rl.Parties, known)...)
+	errs = append(errs, rl.validateSchedules()...)
+	return errors.Join(errs...)
+}
+
+// pickTable 选取 n 落在区间内的第一张表
+func pickTable(tables []CountTable, n int) *CountTable {
+	for i := range tables {
+		tb := &tables[i]
+		if n >= tb.Min && (tb.Max < 0 || n <= tb.Max) {
+			return tb
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package alloc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 时段规则：按 UTC+8（与日志切割一致）的时间窗口切换配额表。
+// 窗口内用 merge_states 中的同名合区规则整体替换默认规则；days 限定星期几，用于每周活动。
+// 同时命中多个窗口时 priority 高者生效，相同则取配置中靠后的一个。
+
+// ScheduleLoc 时段规则使用的时区
+var ScheduleLoc = time.FixedZone("UTC+8", 8*60*60)
+
+type Schedule struct {
+	Name        string      `json:"name"`
+	Days        []int       `json:"days,omitempty"` // 0=周日 … 6=周六，空表示每天
+	Start       string      `json:"start"`          // "HH:MM"
+	End         string      `json:"end"`            // "HH:MM"，不大于 start 表示跨午夜
+	Priority    int         `json:"priority,omitempty"`
+	MergeStates []MergeRule `json:"merge_states"` // 替换同名合区规则，total 须与默认一致
+}
+
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// activeAt 窗口是否覆盖 now；跨午夜的窗口在次日仍按开始当天的 days 判断
+func (s *Schedule) activeAt(now time.Time) bool {
+	start, err1 := parseClock(s.Start)
+	end, err2 := parseClock(s.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	t := now.In(ScheduleLoc)
+	cur := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if end <= start && cur < end {
+		// 跨午夜窗口的后半段
+		day = (day + 6) % 7
+	} else if cur < start || (end > start && cur >= end) {
+		return false
+	}
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveSchedule 返回 now 时生效的时段规则，没有则为 nil
+func (rl *Rules) ActiveSchedule(now time.Time) *Schedule {
+	var best *Schedule
+	for i := range rl.Schedules {
+		s := &rl.Schedules[i]
+		if s.activeAt(now) && (best == nil || s.Priority >= best.Priority) {
+			best = s
+		}
+	}
+	return best
+}
+
+// MergeAt 返回 now 时该合区状态生效的规则，以及替换它的时段（未被替换时为 nil）
+func (rl *Rules) MergeAt(state string, now time.Time) (*MergeRule, *Schedule) {
+	base := rl.Merge(state)
+	s := rl.ActiveSchedule(now)
+	if s == nil {
+		return base, nil
+	}
+	for i := range s.MergeStates {
+		if s.MergeStates[i].Name == base.Name {
+			return &s.MergeStates[i], s
+		}
+	}
+	return base, nil
+}
+
+// ScheduleName 当前生效的时段名，用于检测窗口切换
+func ScheduleName(now time.Time) string {
+	if s := CurrentRules().ActiveSchedule(now); s != nil {
+		return s.Name
+	}
+	return ""
+}
+
+// NextScheduleChange 返回 (now, until] 内生效时段第一次变化（窗口开始或结束）的整分钟，没有时返回 false。
+// 定时循环每分钟检测时段名，变化时所有区服立即重新规划
+func NextScheduleChange(now, until time.Time) (time.Time, bool) {
+	rl := CurrentRules()
+	if len(rl.Schedules) == 0 {
+		return time.Time{}, false
+	}
+	name := func(t time.Time) string {
+		if s := rl.ActiveSchedule(t); s != nil {
+			return s.Name
+		}
+		return ""
+	}
+	cur := name(now)
+	for t := now.Truncate(time.Minute).Add(time.Minute); !t.After(until); t = t.Add(time.Minute) {
+		if name(t) != cur {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// validateSchedules 逐个窗口把替换后的合区规则套入整套规则再校验
+func (rl *Rules) validateSchedules() []error {
+	var errs []error
+	names := map[string]bool{}
+	for _, s := range rl.Schedules {
+		w := "schedules." + s.Name
+		if s.Name == "" || names[s.Name] {
+			errs = append(errs, fmt.Errorf("%s: empty or duplicate name", w))
+		}
+		names[s.Name] = true
+		for _, c := range []string{s.Start, s.End} {
+			if _, err := parseClock(c); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", w, err))
+			}
+		}
+		for _, d := range s.Days {
+			if d < 0 || d > 6 {
+				errs = append(errs, fmt.Errorf("%s: bad day %d", w, d))
+			}
+		}
+		if len(s.MergeStates) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no merge_states", w))
+		}
+		cp := *rl
+		cp.Schedules = nil
+		cp.MergeStates = append([]MergeRule(nil), rl.MergeStates...)
+		for _, ms := range s.MergeStates {
+			found := false
+			for i := range cp.MergeStates {
+				if cp.MergeStates[i].Name != ms.Name {
+					continue
+				}
+				found = true
+				if ms.Total != cp.MergeStates[i].Total {
+					errs = append(errs, fmt.Errorf("%s.%s: total %d, want %d", w, ms.Name, ms.Total, cp.MergeStates[i].Total))
+				}
+				ms.Aliases, ms.Match = cp.MergeStates[i].Aliases, cp.MergeStates[i].Match
+				cp.MergeStates[i] = ms
+			}
+			if !found {
+				errs = append(errs, fmt.Errorf("%s: unknown merge state %q", w, ms.Name))
+			}
+		}
+		if err := cp.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", w, strings.ReplaceAll(err.Error(), "\n", "; ")))
+		}
+	}
+	return errs
+}
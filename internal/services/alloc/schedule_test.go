@@ -0,0 +1,35 @@
+package alloc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextScheduleChange(t *testing.T) {
+	orig := CurrentRules()
+	rl := *orig
+	rl.Schedules = []Schedule{
+		{Name: "周末boss", Days: []int{6, 0}, Start: "20:00", End: "23:00"},
+		{Name: "夜间", Start: "23:30", End: "01:00"},
+	}
+	currentRules.Store(&rl)
+	t.Cleanup(func() { currentRules.Store(orig) })
+
+	at := func(day, hh, mm int) time.Time { return time.Date(2026, 10, day, hh, mm, 0, 0, ScheduleLoc) }
+	cases := []struct {
+		name      string
+		now, want time.Time // want 为零表示 3 小时内不切换
+	}{
+		{"weekday afternoon", at(14, 12, 0), time.Time{}},
+		{"saturday window start", at(17, 19, 30).Add(15 * time.Second), at(17, 20, 0)},
+		{"saturday window end", at(17, 22, 10), at(17, 23, 0)},
+		{"midnight window end", at(18, 0, 20), at(18, 1, 0)},
+		{"weekday night window", at(14, 21, 0), at(14, 23, 30)},
+	}
+	for _, tc := range cases {
+		got, ok := NextScheduleChange(tc.now, tc.now.Add(3*time.Hour))
+		if ok != !tc.want.IsZero() || !got.Equal(tc.want) {
+			t.Errorf("%s: got %v %v, want %v", tc.name, got, ok, tc.want)
+		}
+	}
+}
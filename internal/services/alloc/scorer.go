@@ -0,0 +1,163 @@
+package alloc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"wgserver/internal/services/roles"
+)
+
+// 角色强度评分：规划中的排序、竞争与稳定性比较都使用 Scorer 的总分。
+// 内置 weighted 方式按职业配置各属性权重；其他实现可通过 RegisterScorer 注册后在规则中选用。
+
+// ScoreBreakdown 总分及各项得分（项名 -> 分数，用于分配说明）
+type ScoreBreakdown struct {
+	Total int            `json:"total"`
+	Parts map[string]int `json:"parts,omitempty"`
+}
+
+// String 按项名排序的单行文本，如 "magic=1700,luck=10000"
+func (b ScoreBreakdown) String() string {
+	keys := sortedKeys(b.Parts)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, b.Parts[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+type Scorer interface {
+	Score(r *roles.RoleInfo) ScoreBreakdown
+}
+
+// ScoreWeights 加权评分：各属性 × 权重之和，再加达到门槛的固定加分
+type ScoreWeights struct {
+	Magic        int `json:"magic"`          // 道术
+	HP           int `json:"hp"`             // 血量
+	Skill        int `json:"skill"`          // 技能
+	Level        int `json:"level"`          // 等级
+	Luck         int `json:"luck"`           // 幸运
+	Equip        int `json:"equip"`          // 四主体套装强度（EquipmentRank）
+	Enhance      int `json:"enhance"`        // 已穿戴装备强化等级之和
+	Refine       int `json:"refine"`         // 已穿戴装备淬炼等级之和
+	LuckBonus    int `json:"luck_bonus"`     // 幸运达到 luck_high 时加分
+	LevelBonus   int `json:"level_bonus"`    // 等级达到 level_bonus_at 时加分
+	LevelBonusAt int `json:"level_bonus_at"` // 0 表示不加分
+}
+
+// ScoringRules 规则文件中的评分配置：scorer 为评分方式，classes 按职业覆盖 default
+type ScoringRules struct {
+	Scorer  string                  `json:"scorer"`
+	Default ScoreWeights            `json:"default"`
+	Classes map[string]ScoreWeights `json:"classes"`
+}
+
+const ScorerWeighted = "weighted"
+
+// LegacyScoreWeights 原强度公式：道术 + 幸运达标 10000 + 60 级 5000
+var LegacyScoreWeights = ScoreWeights{Magic: 1, LuckBonus: 10000, LevelBonus: 5000, LevelBonusAt: 60}
+
+var scorers = struct {
+	mu sync.RWMutex
+	m  map[string]func(rl *Rules) Scorer
+}{m: map[string]func(rl *Rules) Scorer{
+	ScorerWeighted: func(rl *Rules) Scorer { return weightedScorer{rules: rl} },
+}}
+
+// RegisterScorer 注册评分方式，规则中 scoring.scorer 填写 name 即可选用
+func RegisterScorer(name string, factory func(rl *Rules) Scorer) {
+	scorers.mu.Lock()
+	defer scorers.mu.Unlock()
+	scorers.m[name] = factory
+}
+
+func scorerFactory(name string) (func(rl *Rules) Scorer, bool) {
+	if name == "" {
+		name = ScorerWeighted
+	}
+	scorers.mu.RLock()
+	defer scorers.mu.RUnlock()
+	f, ok := scorers.m[name]
+	return f, ok
+}
+
+type weightedScorer struct {
+	rules *Rules
+}
+
+func (s weightedScorer) Score(r *roles.RoleInfo) ScoreBreakdown {
+	w, ok := s.rules.Scoring.Classes[r.Class]
+	if !ok {
+		w = s.rules.Scoring.Default
+	}
+	enhance, refine := 0, 0
+	for _, e := range r.Equipments {
+		enhance += e.Enhance
+		refine += e.Refine
+	}
+	parts := map[string]int{}
+	add := func(name string, v int) {
+		if v != 0 {
+			parts[name] = v
+		}
+	}
+	add("magic", w.Magic*r.Magic)
+	add("hp", w.HP*r.HP)
+	add("skill", w.Skill*r.Skill)
+	add("level", w.Level*r.Level)
+	add("luck", w.Luck*r.Lucky)
+	if w.Equip != 0 {
+		add("equip", w.Equip*bestFourPieceRank(s.rules, r))
+	}
+	add("enhance", w.Enhance*enhance)
+	add("refine", w.Refine*refine)
+	if r.Lucky >= s.rules.LuckHigh {
+		add("luck_bonus", w.LuckBonus)
+	}
+	if w.LevelBonusAt > 0 && r.Level >= w.LevelBonusAt {
+		add("level_bonus", w.LevelBonus)
+	}
+	total := 0
+	for _, v := range parts {
+		total += v
+	}
+	return ScoreBreakdown{Total: total, Parts: parts}
+}
+
+// score 本次规划内缓存的评分
+func (p *planner) score(r *roles.RoleInfo) ScoreBreakdown {
+	if b, ok := p.scoreCache[r.RoleName]; ok {
+		return b
+	}
+	if p.scoreCache == nil {
+		p.scoreCache = map[string]ScoreBreakdown{}
+	}
+	if p.scorer == nil {
+		f, ok := scorerFactory(p.rules.Scoring.Scorer)
+		if !ok {
+			f, _ = scorerFactory(ScorerWeighted)
+		}
+		p.scorer = f(p.rules)
+	}
+	b := p.scorer.Score(r)
+	p.scoreCache[r.RoleName] = b
+	return b
+}
+
+func validateScoring(sc ScoringRules) []error {
+	var errs []error
+	if _, ok := scorerFactory(sc.Scorer); !ok {
+		errs = append(errs, fmt.Errorf("scoring: unknown scorer %q", sc.Scorer))
+	}
+	check := func(where string, w ScoreWeights) {
+		if w.LevelBonusAt < 0 {
+			errs = append(errs, fmt.Errorf("%s: level_bonus_at must not be negative", where))
+		}
+	}
+	check("scoring.default", sc.Default)
+	for _, c := range sortedKeys(sc.Classes) {
+		check("scoring.classes."+c, sc.Classes[c])
+	}
+	return errs
+}
@@ -0,0 +1,36 @@
+package alloc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultScoringByClass(t *testing.T) {
+	p := newPlanner("七合", testNow)
+	cases := []struct {
+		class string
+		parts map[string]int
+	}{
+		// 道士、法师沿用原公式
+		{"道士", map[string]int{"magic": 800, "luck_bonus": 10000, "level_bonus": 5000}},
+		// 战士不计道术：技能、四主体强度（天尊 70）、强化与淬炼
+		{"战士", map[string]int{"skill": 600, "equip": 700, "enhance": 160, "refine": 60, "luck_bonus": 10000, "level_bonus": 5000}},
+	}
+	for _, tc := range cases {
+		r := testRole("A", tc.class, 65, 120, 9, 800)
+		r.Equipments = append(r.Equipments[:0:0], r.Equipments...)
+		r.Equipments[0].Enhance, r.Equipments[1].Enhance, r.Equipments[2].Refine = 5, 3, 3
+		b := p.score(r)
+		p.scoreCache = nil
+		if !reflect.DeepEqual(b.Parts, tc.parts) {
+			t.Errorf("%s breakdown = %v, want %v", tc.class, b.Parts, tc.parts)
+		}
+		total := 0
+		for _, v := range tc.parts {
+			total += v
+		}
+		if b.Total != total {
+			t.Errorf("%s total = %d, want %d", tc.class, b.Total, total)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package alloc
+
+import (
+	"sort"
+
+	"wgserver/internal/services/roles"
+)
+
+// 规划稳定性：新计划与上一轮计划对比，若某角色被换到别处、而占据其原目标的角色
+// 与它强度相差不超过 StabilityGain，则两人交换回去，避免无实际收益的来回换图。
+
+// PlanResult 一次规划的结果
+type PlanResult struct {
+	Assignments []Assignment `json:"assignments"`
+	Moves       int          `json:"moves"` // 与上一轮相比目标发生变化的角色数（新加入的角色不计）
+	Kept        int          `json:"kept"`  // 因稳定性规则保留原目标的角色数
+	Mode        string       `json:"mode"`
+	Objective   int64        `json:"objective"`          // 总收益（地图难度 × 角色综合分），用于比较规划方式
+	Schedule    string       `json:"schedule,omitempty"` // 生效的时段规则
+}
+
+// stronger 强度高者优先，强度相同按角色名，保证同一输入得到同一结果
+func (p *planner) stronger(a, b *roles.RoleInfo) bool {
+	sa, sb := p.strengthScore(a), p.strengthScore(b)
+	if sa != sb {
+		return sa > sb
+	}
+	return a.RoleName < b.RoleName
+}
+
+// stabilize 按上一轮计划交换回无明显收益的变动，返回保留原目标的角色数。
+// pin 与法师固定层不参与交换；交换双方都须满足目标地图的进入条件与目标层的等级限制。
+func (p *planner) stabilize(zs *roles.ZoneState, as []Assignment, prev []Assignment) int {
+	if len(prev) == 0 {
+		return 0
+	}
+	prevOf := make(map[string]MapTarget, len(prev))
+	for _, a := range prev {
+		prevOf[a.RoleName] = a.Target
+	}
+	// 按角色名顺序处理，结果与 map 遍历顺序无关
+	idx := make([]int, len(as))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return as[idx[i]].RoleName < as[idx[j]].RoleName })
+	moved := func(i int) bool {
+		pt, ok := prevOf[as[i].RoleName]
+		return !ok || pt != as[i].Target
+	}
+	kept := 0
+	for changed := true; changed; {
+		changed = false
+		for _, i := range idx {
+			pt, ok := prevOf[as[i].RoleName]
+			if !ok || pt == as[i].Target || pinned(as[i]) || mageSeat(as[i]) {
+				continue
+			}
+			ri := zs.Roles[as[i].RoleName]
+			for _, j := range idx {
+				if j == i || as[j].Target != pt || !moved(j) || pinned(as[j]) || mageSeat(as[j]) {
+					continue
+				}
+				rj := zs.Roles[as[j].RoleName]
+				if ri == nil || rj == nil || absInt(p.strengthScore(ri)-p.strengthScore(rj)) > p.rules.StabilityGain {
+					continue
+				}
+				if !p.canEnter(ri, pt.Map) || !p.canEnter(rj, as[i].Target.Map) ||
+					!p.floorAllows(ri, pt) || !p.floorAllows(rj, as[i].Target) || p.fairnessSwapBlocked(pt, as[i].Target) {
+					continue
+				}
+				as[i].Target, as[j].Target = as[j].Target, as[i].Target
+				as[i].Reason, as[j].Reason = as[j].Reason, as[i].Reason
+				if as[i].Reason != nil && as[j].Reason != nil {
+					as[i].Reason.SwappedWith, as[j].Reason.SwappedWith = as[j].RoleName, as[i].RoleName
+				}
+				kept++
+				changed = true
+				break
+			}
+		}
+	}
+	return kept
+}
+
+func pinned(a Assignment) bool { return a.Reason != nil && a.Reason.Branch == BranchPinned }
+
+// mageSeat 是否占用法师固定层名额（固定层可能与普通名额同图同层，只能按分支区分）
+func mageSeat(a Assignment) bool {
+	return a.Reason != nil && (a.Reason.Branch == BranchMageFixed || a.Reason.Branch == BranchMageInsufficient)
+}
+
+// countMoves 统计与上一轮相比目标变化的角色数
+func countMoves(as []Assignment, prev []Assignment) int {
+	prevOf := make(map[string]MapTarget, len(prev))
+	for _, a := range prev {
+		prevOf[a.RoleName] = a.Target
+	}
+	n := 0
+	for _, a := range as {
+		if pt, ok := prevOf[a.RoleName]; ok && pt != a.Target {
+			n++
+		}
+	}
+	return n
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,138 @@
+package alloc
+
+import (
+	"sort"
+	"time"
+
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 空缺递补：已有计划的区服有角色离开时不整体重规划，而是由更低难度地图中最强的可进入角色
+// 接替其名额，被腾出的名额继续由更低难度的角色接替，直到没有候选；未分配的在线角色视为最低一档。
+// 法师固定层只由法师递补，其余名额不从法师固定层抽人；pin 的角色不移动。
+
+const (
+	BranchVacancy = "vacancy_fill"
+	ModeVacancy   = "vacancy"
+)
+
+// floorAllows 层容量配置中该层的等级限制
+func (p *planner) floorAllows(r *roles.RoleInfo, t MapTarget) bool {
+	for _, fc := range p.rules.Floors[t.Map] {
+		if fc.Floor == t.Floor {
+			return r.Level >= fc.MinLevel
+		}
+	}
+	return true
+}
+
+// seatLimits 计划中各图的人数（pin 与法师固定层不计），递补后各图人数不应超过
+func seatLimits(plan []Assignment) map[string]int {
+	out := map[string]int{}
+	for _, a := range plan {
+		if !pinned(a) && !mageSeat(a) {
+			out[a.Target.Map]++
+		}
+	}
+	return out
+}
+
+// FillVacancies 以 prev 为基础递补已离开角色的名额；prev 中没有离开的角色时返回 false
+func FillVacancies(zone string, zs *roles.ZoneState, prev []Assignment) (PlanResult, bool) {
+	var vacant []Assignment // 离开角色的名额（保留分支以区分法师固定层）
+	var left []string
+	as := make([]Assignment, 0, len(prev))
+	for _, a := range prev {
+		if zs.Roles[a.RoleName] == nil {
+			vacant = append(vacant, a)
+			left = append(left, a.RoleName)
+			continue
+		}
+		as = append(as, a)
+	}
+	if len(vacant) == 0 || len(zs.Roles) == 0 {
+		return PlanResult{}, false
+	}
+	p := newPlanner(zsAnyMerge(zs), time.Now())
+	p.total = len(zs.Roles)
+	p.overrides = activeOverrides(zone, p.now)
+
+	// 未分配的在线角色按强度排序，作为最后一档候选
+	assigned := make(map[string]bool, len(as))
+	for _, a := range as {
+		assigned[a.RoleName] = true
+	}
+	var idle []*roles.RoleInfo
+	for name, r := range zs.Roles {
+		if !assigned[name] && !p.overrides.roleExcluded(name) {
+			idle = append(idle, r)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return p.stronger(idle[i], idle[j]) })
+
+	// 难度高的空缺先处理
+	sort.SliceStable(vacant, func(i, j int) bool { return p.difficulty(vacant[i].Target) > p.difficulty(vacant[j].Target) })
+	moved := map[string]bool{}
+	for _, v := range vacant {
+		seat, seatMage := v.Target, mageSeat(v)
+		// 法师固定层保留原分支，其余名额记为递补
+		branch := BranchVacancy
+		if seatMage {
+			branch = v.Reason.Branch
+		}
+		for {
+			best := -1
+			for i, a := range as {
+				r := zs.Roles[a.RoleName]
+				if pinned(a) || p.difficulty(a.Target) >= p.difficulty(seat) || mageSeat(a) != seatMage ||
+					!p.canEnter(r, seat.Map) || !p.floorAllows(r, seat) {
+					continue
+				}
+				if best < 0 || p.stronger(r, zs.Roles[as[best].RoleName]) {
+					best = i
+				}
+			}
+			if best >= 0 {
+				from, fromBranch := as[best].Target, BranchVacancy
+				if seatMage {
+					fromBranch = as[best].Reason.Branch
+				}
+				logger.MapAlloc().Printf("zone=%s vacancy %s-%d filled by role=%s from %s-%d",
+					zone, seat.Map, seat.Floor, as[best].RoleName, from.Map, from.Floor)
+				as[best].Target = seat
+				as[best].Reason = &Reason{Branch: branch}
+				moved[as[best].RoleName] = true
+				seat, branch = from, fromBranch
+				continue
+			}
+			// 没有更低难度的已分配角色时从未分配角色中补入，链条结束
+			for i, r := range idle {
+				if (seatMage && !isMage(r.Class)) || !p.canEnter(r, seat.Map) || !p.floorAllows(r, seat) {
+					continue
+				}
+				logger.MapAlloc().Printf("zone=%s vacancy %s-%d filled by idle role=%s", zone, seat.Map, seat.Floor, r.RoleName)
+				as = append(as, Assignment{RoleName: r.RoleName, Target: seat, Reason: &Reason{Branch: branch}})
+				moved[r.RoleName] = true
+				idle = append(idle[:i], idle[i+1:]...)
+				break
+			}
+			break
+		}
+	}
+
+	// 只为变动的角色补全说明（Reason 为指针，直接更新 as），其余保留上一轮的说明
+	var changed []Assignment
+	for _, a := range as {
+		if moved[a.RoleName] {
+			changed = append(changed, a)
+		}
+	}
+	p.explain(zs, changed)
+	p.formParties(zone, zs, as)
+	res := PlanResult{Assignments: as, Mode: ModeVacancy, Schedule: p.schedule, Objective: p.objective(zs, as), Moves: countMoves(as, prev)}
+	logger.MapAlloc().Printf("zone=%s plan mode=%s left=%v assignments=%d moves=%d version=%d",
+		zone, ModeVacancy, left, len(as), res.Moves, zs.Version)
+	checkPlan(zone, as, zs, seatLimits(prev))
+	return res, true
+}
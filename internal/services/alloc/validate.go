@@ -0,0 +1,140 @@
+package alloc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"wgserver/internal/config"
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 计划不变量检查：同一角色不重复分配、只分配在线角色、进入条件与层等级满足、
+// 法师固定层只由法师占用且每层一人、各图人数不超过本次配额（溢出兜底与 pin 不计），
+// 以及仍有可进入的空位时不留下未分配的在线角色。开发环境（APP_ENV=dev）下每次完整规划与空缺递补后执行，
+// 不满足时写入 map_allocation 日志告警。
+
+var devChecks = sync.OnceValue(func() bool { return config.Load().Env == "dev" })
+
+// Validate 按快照与当前规则检查计划，返回全部违反项（errors.Join），无问题时为 nil
+func Validate(plan []Assignment, zs *roles.ZoneState) error { return validate(plan, zs, nil) }
+
+// validate limits 为空时各图人数上限取本次规则配额；空缺递补沿用上一轮名额，传入上一轮各图人数，
+// 此时不检查未分配角色（递补只补空缺，新上线的角色等待下一次重规划）
+func validate(plan []Assignment, zs *roles.ZoneState, limits map[string]int) error {
+	if len(zs.Roles) == 0 {
+		if len(plan) > 0 {
+			return errors.New("plan for empty zone")
+		}
+		return nil
+	}
+	var zone string
+	for _, r := range zs.Roles {
+		zone = r.Zone
+		break
+	}
+	p := newPlanner(zsAnyMerge(zs), time.Now())
+	p.total = len(zs.Roles)
+	p.overrides = activeOverrides(zone, p.now)
+	_, rest := p.applyOverrides(zs)
+	mages, others := p.splitByClass(rest)
+	insufficient := p.total < p.merge.Total
+	mageTargets, _ := p.mageTargets(insufficient)
+	pool := others
+	if len(mages) > len(mageTargets) {
+		pool = append(append([]*roles.RoleInfo{}, mages[len(mageTargets):]...), others...)
+	}
+	quotas, overflow := p.otherQuotas(pool, insufficient)
+	if limits != nil {
+		quotas, overflow = limits, nil
+	}
+
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	seen := map[string]bool{}
+	count := map[string]int{}
+	mageHeld := map[MapTarget]string{}
+	for _, a := range plan {
+		r := zs.Roles[a.RoleName]
+		if r == nil {
+			bad("role %s assigned but not online", a.RoleName)
+			continue
+		}
+		if seen[a.RoleName] {
+			bad("role %s assigned more than once", a.RoleName)
+			continue
+		}
+		seen[a.RoleName] = true
+		if !p.canEnter(r, a.Target.Map) {
+			_, why := p.entryCheck(r, a.Target.Map)
+			bad("role %s cannot enter %s: %s", a.RoleName, a.Target.Map, why)
+		}
+		if !p.floorAllows(r, a.Target) {
+			bad("role %s below level of %s floor %d", a.RoleName, a.Target.Map, a.Target.Floor)
+		}
+		if pinned(a) {
+			continue
+		}
+		if mageSeat(a) {
+			if !isMage(r.Class) {
+				bad("mage floor %s-%d held by non-mage %s", a.Target.Map, a.Target.Floor, a.RoleName)
+			}
+			if other, ok := mageHeld[a.Target]; ok {
+				bad("mage floor %s-%d held by %s and %s", a.Target.Map, a.Target.Floor, other, a.RoleName)
+			}
+			mageHeld[a.Target] = a.RoleName
+			continue
+		}
+		if a.Reason == nil || a.Reason.Branch != BranchOverflow || limits != nil {
+			count[a.Target.Map]++
+		}
+	}
+	for _, m := range sortedKeys(count) {
+		if count[m] > quotas[m] {
+			bad("map %s has %d roles, quota %d", m, count[m], quotas[m])
+		}
+	}
+	// 有可进入的空位（或兜底地图）却未分配
+	free := func(r *roles.RoleInfo) string {
+		if isMage(r.Class) {
+			for _, mt := range mageTargets {
+				if _, held := mageHeld[mt]; !held && p.canEnter(r, mt.Map) {
+					return fmt.Sprintf("%s-%d", mt.Map, mt.Floor)
+				}
+			}
+		}
+		for _, m := range overflow {
+			if p.canEnter(r, m) {
+				return m
+			}
+		}
+		for _, m := range p.rules.Maps {
+			if count[m] < quotas[m] && p.canEnter(r, m) {
+				return m
+			}
+		}
+		return ""
+	}
+	for _, name := range sortedKeys(rest.Roles) {
+		if seen[name] || limits != nil {
+			continue
+		}
+		if m := free(rest.Roles[name]); m != "" {
+			bad("role %s unassigned while %s has room", name, m)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkPlan 开发环境下校验计划并告警；limits 见 validate
+func checkPlan(zone string, plan []Assignment, zs *roles.ZoneState, limits map[string]int) {
+	if !devChecks() {
+		return
+	}
+	if err := validate(plan, zs, limits); err != nil {
+		logger.MapAlloc().Printf("zone=%s ALERT plan invariant violated: %s", zone, strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+}
@@ -0,0 +1,100 @@
+package alloc
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"wgserver/internal/services/roles"
+	msgtypes "wgserver/internal/types"
+)
+
+// 性质测试：各合区状态下随机生成区服，两种规划方式的完整规划与随后的空缺递补都应满足全部不变量
+
+const propertyRuns = 150
+
+var randomSets = []string{"天尊", "天玄", "道神", "幽泉", "法神", "幻魔", "圣战", "神武", "战神"}
+
+// randomZone 随机人数（1 到满员 +6）、职业、等级、技能、幸运、道术与四主体装备
+func randomZone(rng *rand.Rand, zone, mergeState string, total int) *roles.ZoneState {
+	zs := &roles.ZoneState{Roles: map[string]*roles.RoleInfo{}, ClientByRole: map[string]string{}}
+	classes := []string{"战士", "道士", "法师"}
+	n := 1 + rng.Intn(total+6)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("r%03d", i)
+		var equips []msgtypes.EquipItem
+		if rng.Intn(3) > 0 {
+			set := randomSets[rng.Intn(len(randomSets))]
+			pieces := 2 + rng.Intn(3)
+			for _, slot := range []string{"头盔", "项链", "手镯", "戒指"}[:pieces] {
+				equips = append(equips, msgtypes.EquipItem{Slot: slot, Name: set + slot})
+			}
+		}
+		zs.Roles[name] = &roles.RoleInfo{RoleAttributes: msgtypes.RoleAttributes{
+			RoleName: name, Zone: zone, MergeState: mergeState, Class: classes[rng.Intn(len(classes))],
+			Level: 40 + rng.Intn(41), Skill: rng.Intn(201), Lucky: rng.Intn(10), Magic: rng.Intn(3000),
+			HP: rng.Intn(50000), Equipments: equips,
+		}}
+		zs.ClientByRole[name] = "c" + name
+	}
+	return zs
+}
+
+// withoutRoles 去掉部分角色后的快照（模拟离线）
+func withoutRoles(zs *roles.ZoneState, gone []string) *roles.ZoneState {
+	out := &roles.ZoneState{Roles: map[string]*roles.RoleInfo{}, ClientByRole: map[string]string{}}
+	for name, r := range zs.Roles {
+		out.Roles[name] = r
+		out.ClientByRole[name] = zs.ClientByRole[name]
+	}
+	for _, name := range gone {
+		delete(out.Roles, name)
+		delete(out.ClientByRole, name)
+	}
+	return out
+}
+
+// usePlanner 测试期间替换默认规划方式
+func usePlanner(t testing.TB, mode string) {
+	orig := CurrentRules()
+	rl := *orig
+	rl.Planner = mode
+	currentRules.Store(&rl)
+	t.Cleanup(func() { currentRules.Store(orig) })
+}
+
+func TestPlanInvariants(t *testing.T) {
+	for _, mode := range []string{PlannerRules, PlannerOptimal} {
+		for _, ms := range CurrentRules().MergeStates {
+			t.Run(mode+"/"+ms.Name, func(t *testing.T) {
+				usePlanner(t, mode)
+				for seed := int64(1); seed <= propertyRuns; seed++ {
+					rng := rand.New(rand.NewSource(seed))
+					zone := fmt.Sprintf("性质%s%d", ms.Name, seed)
+					zs := randomZone(rng, zone, ms.Name, ms.Total)
+					res := PlanSnapshot(zone, zs, nil)
+					if err := Validate(res.Assignments, zs); err != nil {
+						t.Fatalf("seed %d (%d roles): %v", seed, len(zs.Roles), err)
+					}
+
+					// 随机离开 1~3 个已分配角色后递补
+					var gone []string
+					for _, i := range rng.Perm(len(res.Assignments))[:min(1+rng.Intn(3), len(res.Assignments))] {
+						gone = append(gone, res.Assignments[i].RoleName)
+					}
+					after := withoutRoles(zs, gone)
+					filled, ok := FillVacancies(zone, after, res.Assignments)
+					if !ok {
+						if len(after.Roles) > 0 {
+							t.Fatalf("seed %d: vacancy not filled after %v left", seed, gone)
+						}
+						continue
+					}
+					if err := validate(filled.Assignments, after, seatLimits(res.Assignments)); err != nil {
+						t.Fatalf("seed %d vacancy after %v left: %v", seed, gone, err)
+					}
+				}
+			})
+		}
+	}
+}
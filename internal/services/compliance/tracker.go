@@ -0,0 +1,251 @@
+package compliance
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wgserver/internal/config"
+	"wgserver/internal/logger"
+	"wgserver/internal/services/roles"
+)
+
+// 分配执行跟踪：推送目标后，根据角色上报的 当前所在地图/X/Y 记录到达耗时，
+// 超时未到达（missed）或到达后离开超过宽限期（wandered）记为一次失败；
+// 连续失败达到阈值的角色交由调用方改派。
+
+// 角色状态
+const (
+	StatusPending  = "pending"  // 已推送，尚未到达
+	StatusArrived  = "arrived"  // 在目标地图
+	StatusMissed   = "missed"   // 超时未到达
+	StatusWandered = "wandered" // 到达后离开超过宽限期
+)
+
+// Record 一个角色当前目标的执行情况
+type Record struct {
+	Role          string    `json:"role"`
+	Map           string    `json:"map"`
+	Floor         int       `json:"floor,omitempty"`
+	Status        string    `json:"status"`
+	AssignedAt    time.Time `json:"assigned_at"`
+	ArriveSeconds int       `json:"arrive_seconds,omitempty"` // 推送到首次到达的耗时
+	CurrentMap    string    `json:"current_map"`
+	X             int       `json:"x"`
+	Y             int       `json:"y"`
+	Failures      int       `json:"failures"` // 连续失败次数，按时到达后清零
+
+	arrivedAt time.Time
+	leftAt    time.Time // 到达后离开的时间，回到目标地图时清空
+	nextMiss  time.Time // 未到达时下一次记为 missed 的时间：目标不变时每过一个到达时限记一次失败
+}
+
+// ZoneStats 区服执行率：rate = arrived / (tracked - pending)
+type ZoneStats struct {
+	Zone             string   `json:"zone"`
+	Tracked          int      `json:"tracked"`
+	Pending          int      `json:"pending"`
+	Arrived          int      `json:"arrived"`
+	Missed           int      `json:"missed"`
+	Wandered         int      `json:"wandered"`
+	Rate             float64  `json:"rate"`
+	AvgArriveSeconds int      `json:"avg_arrive_seconds"`
+	Roles            []Record `json:"roles,omitempty"`
+}
+
+type Tracker struct {
+	mu            sync.Mutex
+	zones         map[string]map[string]*Record // zone -> role -> record
+	arriveTimeout time.Duration
+	wanderGrace   time.Duration
+	reassignAfter int           // 连续失败达到该次数时改派，0 表示不改派
+	reassignTTL   time.Duration // 改派时把角色排除出原目标地图的时长
+}
+
+var singleton *Tracker
+var once sync.Once
+
+func Instance() *Tracker {
+	once.Do(func() {
+		cfg := config.Load()
+		singleton = &Tracker{
+			zones:         map[string]map[string]*Record{},
+			arriveTimeout: cfg.ComplianceArriveTimeout,
+			wanderGrace:   cfg.ComplianceWanderGrace,
+			reassignAfter: cfg.ComplianceReassignAfter,
+			reassignTTL:   cfg.ComplianceReassignTTL,
+		}
+	})
+	return singleton
+}
+
+func (t *Tracker) ReassignTTL() time.Duration { return t.reassignTTL }
+
+// Start 订阅角色事件，跟踪所在地图变化与角色下线
+func (t *Tracker) Start() {
+	ch, _ := roles.Instance().Subscribe(1024)
+	go func() {
+		for ev := range ch {
+			switch ev.Kind {
+			case roles.EventRoleRemoved:
+				t.forget(ev.Zone, ev.Role)
+			case roles.EventMapChanged, roles.EventRoleAdded:
+				if ev.New != nil {
+					t.observe(ev.Zone, ev.New, ev.At)
+				}
+			}
+		}
+	}()
+}
+
+// onMap 上报的地图是否为目标地图；允许 “机关洞5层”“机关洞-5” 这类带层数的写法
+func onMap(reported, target string) bool {
+	if reported == target {
+		return true
+	}
+	rest, ok := strings.CutPrefix(reported, target)
+	return ok && rest != "" && (rest[0] == '-' || (rest[0] >= '0' && rest[0] <= '9') || strings.HasPrefix(rest, "层"))
+}
+
+// Assign 记录推送的目标；目标未变化时不重置计时。ri 为推送时的角色数据，用于判断是否已在目标地图
+func (t *Tracker) Assign(zone string, ri *roles.RoleInfo, mapName string, floor int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	zm := t.zones[zone]
+	if zm == nil {
+		zm = map[string]*Record{}
+		t.zones[zone] = zm
+	}
+	old := zm[ri.RoleName]
+	if old != nil && old.Map == mapName && old.Floor == floor {
+		return
+	}
+	rec := &Record{Role: ri.RoleName, Map: mapName, Floor: floor, Status: StatusPending, AssignedAt: at}
+	if old != nil {
+		rec.Failures = old.Failures
+	}
+	zm[ri.RoleName] = rec
+	t.updateLocked(zone, rec, ri, at)
+}
+
+func (t *Tracker) observe(zone string, ri *roles.RoleInfo, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec := t.zones[zone][ri.RoleName]; rec != nil {
+		t.updateLocked(zone, rec, ri, at)
+	}
+}
+
+func (t *Tracker) updateLocked(zone string, rec *Record, ri *roles.RoleInfo, at time.Time) {
+	rec.CurrentMap, rec.X, rec.Y = ri.MapName, ri.X, ri.Y
+	if !onMap(ri.MapName, rec.Map) {
+		if !rec.arrivedAt.IsZero() && rec.leftAt.IsZero() {
+			rec.leftAt = at
+		}
+		return
+	}
+	rec.leftAt = time.Time{}
+	if rec.arrivedAt.IsZero() {
+		rec.arrivedAt = at
+		rec.ArriveSeconds = int(at.Sub(rec.AssignedAt).Seconds())
+		if rec.Status == StatusPending {
+			rec.Failures = 0
+		}
+		logger.MapAlloc().Printf("zone=%s compliance arrived role=%s map=%s after=%ds pos=%d,%d",
+			zone, rec.Role, rec.Map, rec.ArriveSeconds, rec.X, rec.Y)
+	}
+	rec.Status = StatusArrived
+}
+
+func (t *Tracker) forget(zone, role string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.zones[zone], role)
+	if len(t.zones[zone]) == 0 {
+		delete(t.zones, zone)
+	}
+}
+
+// Tick 判定超时与离开，返回本次连续失败达到改派阈值的角色（zone -> records）
+func (t *Tracker) Tick(now time.Time) map[string][]Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out map[string][]Record
+	for zone, zm := range t.zones {
+		for _, rec := range zm {
+			failed := ""
+			switch {
+			case rec.arrivedAt.IsZero() && t.arriveTimeout > 0:
+				// 稳定性规则使重规划多半保持原目标，Assign 不会重置计时；一直未到达的角色按到达时限逐窗计数
+				if rec.nextMiss.IsZero() {
+					rec.nextMiss = rec.AssignedAt.Add(t.arriveTimeout)
+				}
+				if !now.Before(rec.nextMiss) {
+					failed = StatusMissed
+					rec.nextMiss = rec.nextMiss.Add(t.arriveTimeout)
+				}
+			case rec.Status == StatusArrived && !rec.leftAt.IsZero() && now.Sub(rec.leftAt) >= t.wanderGrace:
+				failed = StatusWandered
+			}
+			if failed == "" {
+				continue
+			}
+			rec.Status = failed
+			rec.Failures++
+			logger.MapAlloc().Printf("zone=%s compliance %s role=%s map=%s current=%s pos=%d,%d failures=%d",
+				zone, failed, rec.Role, rec.Map, rec.CurrentMap, rec.X, rec.Y, rec.Failures)
+			if t.reassignAfter > 0 && rec.Failures >= t.reassignAfter {
+				if out == nil {
+					out = map[string][]Record{}
+				}
+				out[zone] = append(out[zone], *rec)
+				rec.Failures = 0
+			}
+		}
+	}
+	return out
+}
+
+// Stats 区服执行率；zone 为空时返回全部区服，withRoles 附带每个角色的记录
+func (t *Tracker) Stats(zone string, withRoles bool) []ZoneStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := []ZoneStats{}
+	for z, zm := range t.zones {
+		if zone != "" && z != zone {
+			continue
+		}
+		st := ZoneStats{Zone: z, Tracked: len(zm)}
+		arriveSum, arriveN := 0, 0
+		for _, rec := range zm {
+			switch rec.Status {
+			case StatusPending:
+				st.Pending++
+			case StatusArrived:
+				st.Arrived++
+			case StatusMissed:
+				st.Missed++
+			case StatusWandered:
+				st.Wandered++
+			}
+			if !rec.arrivedAt.IsZero() {
+				arriveSum += rec.ArriveSeconds
+				arriveN++
+			}
+			if withRoles {
+				st.Roles = append(st.Roles, *rec)
+			}
+		}
+		if settled := st.Tracked - st.Pending; settled > 0 {
+			st.Rate = float64(st.Arrived) / float64(settled)
+		}
+		if arriveN > 0 {
+			st.AvgArriveSeconds = arriveSum / arriveN
+		}
+		sort.Slice(st.Roles, func(i, j int) bool { return st.Roles[i].Role < st.Roles[j].Role })
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Zone < out[j].Zone })
+	return out
+}
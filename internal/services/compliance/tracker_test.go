@@ -0,0 +1,82 @@
+package compliance
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"wgserver/internal/services/roles"
+	msgtypes "wgserver/internal/types"
+)
+
+// 日志目录为相对路径，测试在临时目录中运行以免在源码树中生成日志
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "compliance-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestTracker(reassignAfter int) *Tracker {
+	return &Tracker{
+		zones:         map[string]map[string]*Record{},
+		arriveTimeout: 10 * time.Minute,
+		wanderGrace:   3 * time.Minute,
+		reassignAfter: reassignAfter,
+		reassignTTL:   time.Hour,
+	}
+}
+
+func roleAt(name, mapName string) *roles.RoleInfo {
+	return &roles.RoleInfo{RoleAttributes: msgtypes.RoleAttributes{RoleName: name, Zone: "测试区", MapName: mapName}}
+}
+
+// 目标保持不变（稳定性规则下的常态）时，一直未到达的角色每个到达时限记一次失败，达到阈值后改派
+func TestTickReassignsStuckRole(t *testing.T) {
+	tr := newTestTracker(3)
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tr.Assign("测试区", roleAt("A", "土城"), "通天塔", 1, start)
+
+	for minute := 1; minute <= 30; minute++ {
+		now := start.Add(time.Duration(minute) * time.Minute)
+		// 每分钟重复推送同一目标
+		tr.Assign("测试区", roleAt("A", "土城"), "通天塔", 1, now)
+		out := tr.Tick(now)
+		rec := tr.zones["测试区"]["A"]
+		switch minute {
+		case 10, 20:
+			if rec.Status != StatusMissed || rec.Failures != minute/10 || out != nil {
+				t.Fatalf("minute %d: status=%s failures=%d out=%v", minute, rec.Status, rec.Failures, out)
+			}
+		case 30:
+			if len(out["测试区"]) != 1 || out["测试区"][0].Role != "A" || out["测试区"][0].Failures != 3 {
+				t.Fatalf("minute 30: reassignment not fired: %v", out)
+			}
+		default:
+			if out != nil {
+				t.Fatalf("minute %d: unexpected reassignment %v", minute, out)
+			}
+		}
+	}
+}
+
+func TestTickArrivalStopsMissCount(t *testing.T) {
+	tr := newTestTracker(2)
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tr.Assign("测试区", roleAt("A", "土城"), "通天塔", 1, start)
+	tr.Tick(start.Add(10 * time.Minute))
+	tr.observe("测试区", roleAt("A", "通天塔3层"), start.Add(12*time.Minute))
+	if out := tr.Tick(start.Add(40 * time.Minute)); out != nil {
+		t.Fatalf("arrived role reassigned: %v", out)
+	}
+	rec := tr.zones["测试区"]["A"]
+	if rec.Status != StatusArrived || rec.Failures != 1 || rec.ArriveSeconds != 720 {
+		t.Fatalf("status=%s failures=%d arrive=%d", rec.Status, rec.Failures, rec.ArriveSeconds)
+	}
+}
@@ -1,62 +1,64 @@
-package equipment
-
-// 职业流派与主体优先顺序、推荐搭配方案
-// 仅存放数据，具体匹配逻辑在 planner.go
-
-type Strategy struct {
-	Schools []string
-	Pri4    []string // 4主体优先顺序（套名前缀）
-	Pri3    []string
-	Pri2    []string
-	Pri1    []string
-	Combos  [][]string // 推荐搭配方案，解析成片段序列，如 ["4主体", "2祝福", "2主体"]
-}
-
-var Strategies = map[string]Strategy{
-	"道士": {
-		Schools: []string{"灵医", "天尊", "御兽"},
-		Pri4:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "道神", "幽泉", "御灵"},
-		Pri3:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "道神", "幽泉", "御灵"},
-		Pri2:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "封雷", "帝释", "道神", "幽泉", "御灵"},
-		Pri1:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "道神", "幽泉", "御灵"},
-		Combos: [][]string{
-			{"4主体", "4其他主体"},
-			{"4主体", "2主体", "2其他主体"},
-			{"4主体", "2祝福", "2主体"},
-			{"4主体", "2主体", "1主体", "1轩辕之心"},
-			{"4主体", "3主体", "1轩辕之心"},
-		},
-	},
-	"法师": {
-		Schools: []string{"狂雷", "玄冰", "赤炎"},
-		Pri4:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "魔神"},
-		Pri3:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "魔神"},
-		Pri2:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "封雷", "帝释", "魔神"},
-		Pri1:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "魔神"},
-		Combos: [][]string{
-			{"4主体", "4其他主体"},
-			{"4主体", "2主体", "2其他主体"},
-			{"4主体", "2祝福", "2主体"},
-			{"4主体", "3天机", "1主体"},
-			{"3天机", "2祝福", "3主体"},
-		},
-	},
-	"战士": {
-		Schools: []string{"战神", "暴君", "武皇"},
-		Pri4:    []string{"圣战", "天之圣战", "神武", "天之神武", "战神"},
-		Pri3:    []string{"圣战", "天之圣战", "神武", "天之神武", "战神"},
-		Pri2:    []string{"圣战", "天之圣战", "神武", "天之神武", "封雷", "帝释", "战神"},
-		Pri1:    []string{"圣战", "天之圣战", "神武", "天之神武", "战神", "天机", "疾风"},
-		Combos: [][]string{
-			{"4主体", "4其他主体"},
-			{"4主体", "2主体", "2其他主体"},
-			{"4主体", "2祝福", "2主体"},
-			{"4主体", "3天机/疾风", "1主体"},
-			{"3疾风", "2祝福", "3主体"},
-			{"3疾风", "2祝福", "2主体", "1主体"},
-		},
-	},
-}
-
-// 轩辕之心（特殊）
-const XuanYuanHeart = "轩辕之心"
+package equipment
+
+// 职业流派与主体优先顺序、推荐搭配方案
+// 仅存放数据，具体匹配逻辑在 planner.go
+
+import t "wgserver/internal/types"
+
+type Strategy struct {
+	Schools []string
+	Pri4    []string // 4主体优先顺序（套名前缀）
+	Pri3    []string
+	Pri2    []string
+	Pri1    []string
+	Combos  [][]string // 推荐搭配方案，解析成片段序列，如 ["4主体", "2祝福", "2主体"]
+}
+
+var Strategies = map[string]Strategy{
+	"道士": {
+		Schools: t.ClassSchools["道士"],
+		Pri4:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "道神", "幽泉", "御灵"},
+		Pri3:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "道神", "幽泉", "御灵"},
+		Pri2:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "封雷", "帝释", "道神", "幽泉", "御灵"},
+		Pri1:    []string{"天尊", "天之天尊", "天玄", "天之天玄", "道神", "幽泉", "御灵"},
+		Combos: [][]string{
+			{"4主体", "4其他主体"},
+			{"4主体", "2主体", "2其他主体"},
+			{"4主体", "2祝福", "2主体"},
+			{"4主体", "2主体", "1主体", "1轩辕之心"},
+			{"4主体", "3主体", "1轩辕之心"},
+		},
+	},
+	"法师": {
+		Schools: t.ClassSchools["法师"],
+		Pri4:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "魔神"},
+		Pri3:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "魔神"},
+		Pri2:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "封雷", "帝释", "魔神"},
+		Pri1:    []string{"法神", "天之法神", "幻魔", "天之幻魔", "魔神"},
+		Combos: [][]string{
+			{"4主体", "4其他主体"},
+			{"4主体", "2主体", "2其他主体"},
+			{"4主体", "2祝福", "2主体"},
+			{"4主体", "3天机", "1主体"},
+			{"3天机", "2祝福", "3主体"},
+		},
+	},
+	"战士": {
+		Schools: t.ClassSchools["战士"],
+		Pri4:    []string{"圣战", "天之圣战", "神武", "天之神武", "战神"},
+		Pri3:    []string{"圣战", "天之圣战", "神武", "天之神武", "战神"},
+		Pri2:    []string{"圣战", "天之圣战", "神武", "天之神武", "封雷", "帝释", "战神"},
+		Pri1:    []string{"圣战", "天之圣战", "神武", "天之神武", "战神", "天机", "疾风"},
+		Combos: [][]string{
+			{"4主体", "4其他主体"},
+			{"4主体", "2主体", "2其他主体"},
+			{"4主体", "2祝福", "2主体"},
+			{"4主体", "3天机/疾风", "1主体"},
+			{"3疾风", "2祝福", "3主体"},
+			{"3疾风", "2祝福", "2主体", "1主体"},
+		},
+	},
+}
+
+// 轩辕之心（特殊）
+const XuanYuanHeart = "轩辕之心"
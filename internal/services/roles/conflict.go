@@ -0,0 +1,157 @@
+package roles
+
+import (
+	"time"
+
+	"wgserver/internal/logger"
+	t "wgserver/internal/types"
+)
+
+// 角色归属冲突：同一区服同名角色被两个在线客户端同时上报
+
+type ConflictPolicy string
+
+const (
+	ConflictKeepFirst ConflictPolicy = "keep_first" // 保留先上报的客户端，忽略后来者
+	ConflictTakeOver  ConflictPolicy = "take_over"  // 后来者接管角色（旧行为）
+	ConflictReject    ConflictPolicy = "reject"     // 双方均不采信，角色移出规划直至一方断开
+)
+
+// ParseConflictPolicy 解析配置值，未知值按 take_over 处理以保持兼容
+func ParseConflictPolicy(s string) ConflictPolicy {
+	switch ConflictPolicy(s) {
+	case ConflictKeepFirst, ConflictReject:
+		return ConflictPolicy(s)
+	default:
+		return ConflictTakeOver
+	}
+}
+
+// SetConflictPolicy 设置冲突处理策略；由启动流程按配置注入，未设置时为 take_over
+func (m *Manager) SetConflictPolicy(p ConflictPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conflictPolicy = p
+}
+
+// 同一组客户端反复争用（如 take_over 下两端来回接管）时，日志与通知按该间隔限流，期间的次数随下一条一起记录
+const conflictNoticeEvery = time.Minute
+
+// conflictState 一个角色的争用状态
+type conflictState struct {
+	clients  map[string]struct{} // 争用该角色的 client_id
+	noticed  time.Time           // 最近一次记录与通知的时间
+	repeated int                 // 上次通知后被限流的次数
+}
+
+// conflictNotice 待发送的冲突通知；在释放 Manager 写锁后由 sendConflictNotices 发出
+type conflictNotice struct {
+	clientID string
+	payload  map[string]any
+}
+
+type senderFn func(clientID string, payload any)
+
+var send senderFn
+
+// SetSender sets the function used to notify clients about role conflicts
+func SetSender(fn senderFn) { send = fn }
+
+// resolveOwnerLocked 判定本次上报是否可以写入；调用方需持有写锁，zs 为 editZoneLocked 得到的副本。
+// 新出现的冲突立即记录与通知；已知客户端之间的重复冲突按 conflictNoticeEvery 限流。
+// 返回的通知须在释放写锁后发送。
+func (m *Manager) resolveOwnerLocked(zs *ZoneState, r *t.RoleAttributes, now time.Time) (bool, []conflictNotice) {
+	key := persistKey(r.Zone, r.RoleName)
+	cs := m.conflicts[key]
+	if m.conflictPolicy == ConflictReject && cs != nil && len(cs.clients) > 1 {
+		if _, known := cs.clients[r.ClientID]; !known && r.ClientID != "" {
+			cs.clients[r.ClientID] = struct{}{}
+			cs.noticed = now
+			return false, conflictNotices(r, "", "拒绝")
+		}
+		return false, nil
+	}
+
+	owner := zs.ClientByRole[r.RoleName]
+	if owner == "" || r.ClientID == "" || owner == r.ClientID {
+		return true, nil
+	}
+
+	if cs == nil {
+		cs = &conflictState{clients: map[string]struct{}{}}
+		m.conflicts[key] = cs
+	}
+	_, knownOwner := cs.clients[owner]
+	_, knownNew := cs.clients[r.ClientID]
+	cs.clients[owner] = struct{}{}
+	cs.clients[r.ClientID] = struct{}{}
+	notify := !knownOwner || !knownNew || now.Sub(cs.noticed) >= conflictNoticeEvery
+	if notify {
+		logger.RoleInfo().Printf("conflict zone=%s role=%s owner=%s challenger=%s policy=%s repeated=%d",
+			r.Zone, r.RoleName, owner, r.ClientID, m.conflictPolicy, cs.repeated)
+		cs.noticed, cs.repeated = now, 0
+	} else {
+		cs.repeated++
+	}
+
+	var outcome string
+	ok := false
+	switch m.conflictPolicy {
+	case ConflictKeepFirst:
+		outcome = "保留"
+	case ConflictReject:
+		if ri := zs.Roles[r.RoleName]; ri != nil {
+			m.publish(removedEvent(ri, "conflict"))
+		}
+		delete(zs.Roles, r.RoleName)
+		delete(zs.ClientByRole, r.RoleName)
+		m.commitZoneLocked(r.Zone, zs)
+		outcome = "拒绝"
+	default:
+		outcome, ok = "接管", true
+	}
+	if !notify {
+		return ok, nil
+	}
+	return ok, conflictNotices(r, owner, outcome)
+}
+
+// conflictNotices 生成给冲突双方的通知；outcome 描述后来者（r.ClientID）得到的处理结果
+func conflictNotices(r *t.RoleAttributes, owner, outcome string) []conflictNotice {
+	msg := func(cid, other, result string) conflictNotice {
+		return conflictNotice{clientID: cid, payload: map[string]any{
+			"角色名":       r.RoleName,
+			"充值区服":      r.Zone,
+			"消息类型":      string(t.MsgTypeRoleConflict),
+			"冲突客户端":     other,
+			"处理结果":      result,
+			"client_id": cid,
+		}}
+	}
+	notes := []conflictNotice{msg(r.ClientID, owner, outcome)}
+	if owner != "" {
+		ownerResult := map[string]string{"保留": "保留", "接管": "被接管", "拒绝": "拒绝"}[outcome]
+		notes = append(notes, msg(owner, r.ClientID, ownerResult))
+	}
+	return notes
+}
+
+// sendConflictNotices 发送冲突通知；不得持有 Manager 的锁调用
+func sendConflictNotices(notes []conflictNotice) {
+	if send == nil {
+		return
+	}
+	for _, n := range notes {
+		send(n.clientID, n.payload)
+	}
+}
+
+// clearConflictsLocked 客户端断开后将其移出所有冲突集合；只剩一方时冲突解除
+func (m *Manager) clearConflictsLocked(clientID string) {
+	for key, cs := range m.conflicts {
+		delete(cs.clients, clientID)
+		if len(cs.clients) <= 1 {
+			delete(m.conflicts, key)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package roles
+
+import (
+	"sync"
+	"time"
+)
+
+// 角色变更事件：下游服务订阅后按事件增量处理，而不是轮询 SnapshotZone
+
+type EventKind string
+
+const (
+	EventRoleAdded        EventKind = "role_added"
+	EventRoleRemoved      EventKind = "role_removed"
+	EventMapChanged       EventKind = "map_changed"
+	EventEquipmentChanged EventKind = "equipment_changed"
+	EventLevelThreshold   EventKind = "level_threshold" // 等级跨越 LevelThresholds 中的门槛
+	EventSkillThreshold   EventKind = "skill_threshold" // 技能跨越 SkillThresholds 中的门槛
+	EventLuckThreshold    EventKind = "luck_threshold"  // 幸运跨越 LuckThresholds 中的门槛
+)
+
+// 规划相关门槛；跨越（上升或下降）时发布对应事件。由规划规则通过 SetThresholds 更新。
+var (
+	LevelThresholds = []int{60}
+	SkillThresholds = []int{150}
+	LuckThresholds  = []int{9}
+)
+
+// Event 中的 RoleInfo 与管理器内部共享，订阅方只读不可修改
+type Event struct {
+	Kind     EventKind
+	Zone     string
+	Role     string
+	ClientID string
+	Old      *RoleInfo // 变更前（新增时为 nil）
+	New      *RoleInfo // 变更后（移除时为 nil）
+	Reason   string    // 移除原因：disconnect / conflict / merge 等
+	At       time.Time
+}
+
+// SetThresholds 更新事件门槛（持写锁，与 diffEvents 互斥）
+func (m *Manager) SetThresholds(level, skill, luck []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	LevelThresholds = append([]int(nil), level...)
+	SkillThresholds = append([]int(nil), skill...)
+	LuckThresholds = append([]int(nil), luck...)
+}
+
+type subscriber struct {
+	ch      chan Event
+	dropped uint64
+}
+
+type eventHub struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]*subscriber
+}
+
+// Subscribe 订阅角色事件；buf 为通道缓冲，消费过慢时事件被丢弃并计数。
+// 返回的 cancel 关闭通道并取消订阅。
+func (m *Manager) Subscribe(buf int) (<-chan Event, func()) {
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+	if m.events.subs == nil {
+		m.events.subs = map[int]*subscriber{}
+	}
+	id := m.events.next
+	m.events.next++
+	s := &subscriber{ch: make(chan Event, buf)}
+	m.events.subs[id] = s
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			m.events.mu.Lock()
+			delete(m.events.subs, id)
+			close(s.ch)
+			m.events.mu.Unlock()
+		})
+	}
+	return s.ch, cancel
+}
+
+// DroppedEvents 返回所有订阅者累计丢弃的事件数
+func (m *Manager) DroppedEvents() uint64 {
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+	var n uint64
+	for _, s := range m.events.subs {
+		n += s.dropped
+	}
+	return n
+}
+
+func (m *Manager) publish(ev Event) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	m.events.mu.Lock()
+	defer m.events.mu.Unlock()
+	for _, s := range m.events.subs {
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// diffEvents 比较同一角色两次上报，生成变更事件
+func diffEvents(prev *RoleInfo, cur *RoleInfo) []Event {
+	base := Event{Zone: cur.Zone, Role: cur.RoleName, ClientID: cur.ClientID, Old: prev, New: cur}
+	if prev == nil {
+		ev := base
+		ev.Kind = EventRoleAdded
+		return []Event{ev}
+	}
+	var out []Event
+	add := func(k EventKind) {
+		ev := base
+		ev.Kind = k
+		out = append(out, ev)
+	}
+	if prev.MapName != cur.MapName {
+		add(EventMapChanged)
+	}
+	if !equipEqual(prev.Equipments, cur.Equipments) {
+		add(EventEquipmentChanged)
+	}
+	if crossed(prev.Level, cur.Level, LevelThresholds) {
+		add(EventLevelThreshold)
+	}
+	if crossed(prev.Skill, cur.Skill, SkillThresholds) {
+		add(EventSkillThreshold)
+	}
+	if crossed(prev.Lucky, cur.Lucky, LuckThresholds) {
+		add(EventLuckThreshold)
+	}
+	return out
+}
+
+func removedEvent(ri *RoleInfo, reason string) Event {
+	return Event{Kind: EventRoleRemoved, Zone: ri.Zone, Role: ri.RoleName, ClientID: ri.ClientID, Old: ri, Reason: reason}
+}
+
+func crossed(a, b int, thresholds []int) bool {
+	for _, th := range thresholds {
+		if (a >= th) != (b >= th) {
+			return true
+		}
+	}
+	return false
+}
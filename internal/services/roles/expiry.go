@@ -0,0 +1,65 @@
+package roles
+
+import (
+	"sort"
+	"time"
+
+	"wgserver/internal/logger"
+)
+
+// 过期清理：客户端仍在线但角色长时间未上报（卡死、切换到其他角色等），
+// 将其移出在线快照转入离线表，规划不再计入；重新上报后自动恢复。
+
+// SetStaleAfter 设置角色转为离线的未上报时长；由启动流程按配置注入，0（默认）表示不清理
+func (m *Manager) SetStaleAfter(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.staleAfter = d
+}
+
+// ExpireStale 将超过 staleAfter 未更新的角色转为离线，返回被转出的角色数
+func (m *Manager) ExpireStale(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.staleAfter <= 0 {
+		return 0
+	}
+	n := 0
+	for z, cur := range m.zones {
+		var zs *ZoneState
+		for name, ri := range cur.Roles {
+			if ri.UpdatedAt.IsZero() || now.Sub(ri.UpdatedAt) < m.staleAfter {
+				continue
+			}
+			if zs == nil {
+				zs = cur.clone()
+			}
+			delete(zs.Roles, name)
+			delete(zs.ClientByRole, name)
+			if m.offline[z] == nil {
+				m.offline[z] = map[string]*RoleInfo{}
+			}
+			m.offline[z][name] = ri
+			n++
+			logger.RoleInfo().Printf("expired role=%s zone=%s client_id=%s last_update=%s idle=%s",
+				name, z, ri.ClientID, ri.UpdatedAt.Format(time.RFC3339), now.Sub(ri.UpdatedAt).Truncate(time.Second))
+			m.publish(removedEvent(ri, "expired"))
+		}
+		if zs != nil {
+			m.commitZoneLocked(z, zs)
+		}
+	}
+	return n
+}
+
+// OfflineRoles 返回区服内因超时转为离线的角色名（已排序）
+func (m *Manager) OfflineRoles(zone string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]string, 0, len(m.offline[zone]))
+	for name := range m.offline[zone] {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -1,157 +1,273 @@
-package roles
-
-import (
-	"encoding/json"
-	"sync"
-	"time"
-
-	"wgserver/internal/db"
-	"wgserver/internal/logger"
-	t "wgserver/internal/types"
-
-	"github.com/jmoiron/sqlx"
-)
-
-type RoleInfo struct{ t.RoleAttributes }
-
-type ZoneState struct {
-	Roles          map[string]*RoleInfo // role_name -> info
-	ClientByRole   map[string]string    // role -> client_id
-	LastUpdate     time.Time
-	WaitAllocUntil time.Time // deadline to wait for more roles (3min)
-}
-
-type Manager struct {
-	mu    sync.RWMutex
-	zones map[string]*ZoneState
-}
-
-var singleton *Manager
-var once sync.Once
-
-func Instance() *Manager {
-	once.Do(func() { singleton = &Manager{zones: make(map[string]*ZoneState)} })
-	return singleton
-}
-
-func (m *Manager) UpsertRole(raw []byte) (*RoleInfo, bool) {
-	var r t.RoleAttributes
-	if err := json.Unmarshal(raw, &r); err != nil {
-		return nil, false
-	}
-	z := r.Zone
-	if z == "" || r.RoleName == "" {
-		return nil, false
-	}
-
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	zs := m.zones[z]
-	if zs == nil {
-		zs = &ZoneState{Roles: map[string]*RoleInfo{}, ClientByRole: map[string]string{}}
-		m.zones[z] = zs
-	}
-
-	prev, exists := zs.Roles[r.RoleName]
-	// 变更检测：仅当新角色，或当前地图/装备发生变化时记录 role_info
-	shouldLog := !exists
-	if exists {
-		// 地图变化
-		if prev.MapName != r.MapName {
-			shouldLog = true
-		}
-		// 装备变化
-		if !equipEqual(prev.Equipments, r.Equipments) {
-			shouldLog = true
-		}
-	}
-	zs.Roles[r.RoleName] = &RoleInfo{RoleAttributes: r}
-	zs.ClientByRole[r.RoleName] = r.ClientID
-	zs.LastUpdate = time.Now()
-	// 滑动窗口：每次有新角色或属性更新，若仍未达到阈值，将等待截止时间向后推 3 分钟；
-	// 是否达到阈值的判定由上层 server 在推送/分配前进行，因此这里无须了解阈值具体数值
-	zs.WaitAllocUntil = time.Now().Add(3 * time.Minute)
-
-	// persist new/changed role to DB, and log only when new or map/equipment changed (TODO: diff detection)
-	saveRole(&r)
-	if shouldLog {
-		logger.RoleInfo().Printf("role=%s zone=%s merge=%s class=%s school=%s magic=%d lucky=%d level=%d skill=%d map=%s",
-			r.RoleName, r.Zone, r.MergeState, r.Class, r.School, r.Magic, r.Lucky, r.Level, r.Skill, r.MapName)
-	}
-	return zs.Roles[r.RoleName], !exists
-}
-
-func (m *Manager) RemoveClient(clientID string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	for _, zs := range m.zones {
-		for role, cid := range zs.ClientByRole {
-			if cid == clientID {
-				delete(zs.Roles, role)
-				delete(zs.ClientByRole, role)
-			}
-		}
-	}
-}
-
-func (m *Manager) SnapshotZone(zone string) *ZoneState {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	if zs, ok := m.zones[zone]; ok {
-		copy := &ZoneState{Roles: make(map[string]*RoleInfo), ClientByRole: make(map[string]string), LastUpdate: zs.LastUpdate, WaitAllocUntil: zs.WaitAllocUntil}
-		for k, v := range zs.Roles {
-			vv := *v
-			copy.Roles[k] = &vv
-		}
-		for k, v := range zs.ClientByRole {
-			copy.ClientByRole[k] = v
-		}
-		return copy
-	}
-	return &ZoneState{Roles: map[string]*RoleInfo{}, ClientByRole: map[string]string{}}
-}
-
-func (m *Manager) ListZones() []string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	out := make([]string, 0, len(m.zones))
-	for z := range m.zones {
-		out = append(out, z)
-	}
-	return out
-}
-
-func saveRole(r *t.RoleAttributes) {
-	_ = db.Tx(func(tx *sqlx.Tx) error {
-		_, err := tx.Exec(`INSERT INTO roles (role_name, zone, merge_state, class, school, skill, level, lucky, magic, current_map, client_id, created_at, x, y)
-		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)
-		ON DUPLICATE KEY UPDATE merge_state=VALUES(merge_state), class=VALUES(class), school=VALUES(school), skill=VALUES(skill), level=VALUES(level), lucky=VALUES(lucky), magic=VALUES(magic), current_map=VALUES(current_map), client_id=VALUES(client_id), x=VALUES(x), y=VALUES(y)`,
-			r.RoleName, r.Zone, r.MergeState, r.Class, r.School, r.Skill, r.Level, r.Lucky, r.Magic, r.MapName, r.ClientID, r.CreatedAt, r.X, r.Y)
-		return err
-	})
-}
-
-// 判断装备集合是否相同（按装备名+部位去重）；不关注顺序
-func equipEqual(a, b []t.EquipItem) bool {
-	if len(a) != len(b) {
-		// 可能位置名称不同但数量相同，这里仍需集合比较
-	}
-	set := func(list []t.EquipItem) map[string]int {
-		m := map[string]int{}
-		for _, e := range list {
-			key := e.Slot + "|" + e.Name
-			m[key]++
-		}
-		return m
-	}
-	sa, sb := set(a), set(b)
-	if len(sa) != len(sb) {
-		return false
-	}
-	for k, va := range sa {
-		if vb, ok := sb[k]; !ok || vb != va {
-			return false
-		}
-	}
-	return true
-}
+package roles
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"wgserver/internal/logger"
+	t "wgserver/internal/types"
+)
+
+type RoleInfo struct {
+	t.RoleAttributes
+	UpdatedAt time.Time `json:"-"` // 最近一次被采信的上报时间
+}
+
+// ZoneState 是区服的不可变快照：管理器每次变更都生成新对象并整体替换，
+// 读者拿到的指针始终是一致视图。调用方不得修改其中的 map 或 RoleInfo。
+type ZoneState struct {
+	Roles          map[string]*RoleInfo // role_name -> info
+	ClientByRole   map[string]string    // role -> client_id
+	LastUpdate     time.Time
+	WaitAllocUntil time.Time // deadline to wait for more roles (3min)
+	Version        uint64    // 单调递增；相同版本即相同内容，可用于缓存与过期检测
+}
+
+// clone 浅拷贝：复制两张 map，RoleInfo 指针共享（RoleInfo 只替换不修改）
+func (zs *ZoneState) clone() *ZoneState {
+	c := &ZoneState{
+		Roles:          make(map[string]*RoleInfo, len(zs.Roles)+1),
+		ClientByRole:   make(map[string]string, len(zs.ClientByRole)+1),
+		LastUpdate:     zs.LastUpdate,
+		WaitAllocUntil: zs.WaitAllocUntil,
+		Version:        zs.Version,
+	}
+	for k, v := range zs.Roles {
+		c.Roles[k] = v
+	}
+	for k, v := range zs.ClientByRole {
+		c.ClientByRole[k] = v
+	}
+	return c
+}
+
+type Manager struct {
+	mu      sync.RWMutex
+	zones   map[string]*ZoneState // 当前快照；只通过 editZoneLocked/commitZoneLocked 替换
+	version uint64                // 全局快照版本计数
+	persist *persister            // 可选：角色写回队列，见 StartPersister
+
+	// 归属冲突：zone|role -> 争用状态
+	conflicts      map[string]*conflictState
+	conflictPolicy ConflictPolicy
+
+	// 区服注册表：别名 -> 合并后区服；区服 -> 管理员指定的合区状态
+	aliases     map[string]string
+	mergeStates map[string]string
+
+	events eventHub
+
+	// 属性校验：规则、隔离区（zone|role -> 最新可疑上报）与每个角色最近一次被采信的数据
+	validation ValidationRules
+	quarantine map[string]*QuarantineEntry
+	baseline   map[string]*RoleInfo
+
+	// 超时未上报的角色：zone -> role -> 最后一次数据
+	staleAfter time.Duration
+	offline    map[string]map[string]*RoleInfo
+}
+
+var singleton *Manager
+var once sync.Once
+
+func Instance() *Manager {
+	once.Do(func() {
+		singleton = &Manager{
+			zones:          make(map[string]*ZoneState),
+			conflicts:      make(map[string]*conflictState),
+			conflictPolicy: ConflictTakeOver,
+			aliases:        make(map[string]string),
+			mergeStates:    make(map[string]string),
+			validation:     DefaultValidationRules(),
+			quarantine:     make(map[string]*QuarantineEntry),
+			baseline:       make(map[string]*RoleInfo),
+			offline:        make(map[string]map[string]*RoleInfo),
+		}
+	})
+	return singleton
+}
+
+func (m *Manager) UpsertRole(raw []byte) (*RoleInfo, bool) {
+	var r t.RoleAttributes
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, false
+	}
+	if r.Zone == "" || r.RoleName == "" {
+		return nil, false
+	}
+	return m.upsert(r, true)
+}
+
+// upsert 写入一次上报；validate 为 false 时跳过属性校验（管理员放行隔离数据）
+func (m *Manager) upsert(r t.RoleAttributes, validate bool) (*RoleInfo, bool) {
+	now := time.Now()
+	var notes []conflictNotice
+	defer func() { sendConflictNotices(notes) }() // 在释放写锁之后发送
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 合区后旧区服名按别名归入合并后的区服，合区状态以管理员设置为准
+	r.Zone = m.resolveZoneLocked(r.Zone)
+	if ms := m.mergeStates[r.Zone]; ms != "" {
+		r.MergeState = ms
+	}
+	zs := m.editZoneLocked(r.Zone)
+
+	// 同名角色被其他在线客户端持有时按冲突策略处理
+	ok, notes := m.resolveOwnerLocked(zs, &r, now)
+	if !ok {
+		return nil, false
+	}
+
+	key := persistKey(r.Zone, r.RoleName)
+	if validate {
+		if reasons := m.validation.validate(m.baseline[key], &r, now); len(reasons) > 0 {
+			m.quarantineLocked(r, reasons, now)
+			return nil, false
+		}
+	}
+	delete(m.quarantine, key)
+
+	if _, ok := m.offline[r.Zone][r.RoleName]; ok {
+		delete(m.offline[r.Zone], r.RoleName)
+		logger.RoleInfo().Printf("online again role=%s zone=%s client_id=%s", r.RoleName, r.Zone, r.ClientID)
+	}
+
+	prev, exists := zs.Roles[r.RoleName]
+	cur := &RoleInfo{RoleAttributes: r, UpdatedAt: now}
+	m.baseline[key] = cur
+	// 变更检测：仅当新角色，或当前地图/装备发生变化时记录 role_info
+	evs := diffEvents(prev, cur)
+	shouldLog := false
+	for _, ev := range evs {
+		switch ev.Kind {
+		case EventRoleAdded, EventMapChanged, EventEquipmentChanged:
+			shouldLog = true
+		}
+	}
+	zs.Roles[r.RoleName] = cur
+	zs.ClientByRole[r.RoleName] = r.ClientID
+	zs.LastUpdate = now
+	// 滑动窗口：每次有新角色或属性更新，若仍未达到阈值，将等待截止时间向后推 3 分钟；
+	// 是否达到阈值的判定由上层 server 在推送/分配前进行，因此这里无须了解阈值具体数值
+	zs.WaitAllocUntil = now.Add(3 * time.Minute)
+	m.commitZoneLocked(r.Zone, zs)
+
+	// 落库交给写回队列异步批量完成，这里只入队，不在全局写锁内访问数据库
+	if m.persist != nil {
+		m.persist.enqueue(r)
+	}
+	if shouldLog {
+		logger.RoleInfo().Printf("role=%s zone=%s merge=%s class=%s school=%s magic=%d lucky=%d level=%d skill=%d map=%s",
+			r.RoleName, r.Zone, r.MergeState, r.Class, r.School, r.Magic, r.Lucky, r.Level, r.Skill, r.MapName)
+	}
+	for _, ev := range evs {
+		m.publish(ev)
+	}
+	return cur, !exists
+}
+
+func (m *Manager) RemoveClient(clientID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for z, cur := range m.zones {
+		var zs *ZoneState
+		for role, cid := range cur.ClientByRole {
+			if cid != clientID {
+				continue
+			}
+			if zs == nil {
+				zs = cur.clone()
+			}
+			if ri := zs.Roles[role]; ri != nil {
+				m.publish(removedEvent(ri, "disconnect"))
+			}
+			delete(zs.Roles, role)
+			delete(zs.ClientByRole, role)
+		}
+		if zs != nil {
+			m.commitZoneLocked(z, zs)
+		}
+	}
+	// 客户端断开后其离线角色一并清理
+	for _, off := range m.offline {
+		for name, ri := range off {
+			if ri.ClientID == clientID {
+				delete(off, name)
+			}
+		}
+	}
+	m.clearConflictsLocked(clientID)
+}
+
+// editZoneLocked 返回区服当前快照的可写副本（不存在则新建）；修改完成后需 commitZoneLocked
+func (m *Manager) editZoneLocked(zone string) *ZoneState {
+	if cur := m.zones[zone]; cur != nil {
+		return cur.clone()
+	}
+	return &ZoneState{Roles: map[string]*RoleInfo{}, ClientByRole: map[string]string{}}
+}
+
+// commitZoneLocked 赋予新版本号并替换快照
+func (m *Manager) commitZoneLocked(zone string, zs *ZoneState) {
+	m.version++
+	zs.Version = m.version
+	m.zones[zone] = zs
+}
+
+// SnapshotZone 返回区服当前的不可变快照（不复制），调用方只读
+func (m *Manager) SnapshotZone(zone string) *ZoneState {
+	m.mu.RLock()
+	zs, ok := m.zones[zone]
+	m.mu.RUnlock()
+	if ok {
+		return zs
+	}
+	return &ZoneState{Roles: map[string]*RoleInfo{}, ClientByRole: map[string]string{}}
+}
+
+// SnapshotVersion 返回区服当前快照版本（区服不存在为 0）
+func (m *Manager) SnapshotVersion(zone string) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if zs, ok := m.zones[zone]; ok {
+		return zs.Version
+	}
+	return 0
+}
+
+func (m *Manager) ListZones() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]string, 0, len(m.zones))
+	for z := range m.zones {
+		out = append(out, z)
+	}
+	return out
+}
+
+// 判断装备集合是否相同（按装备名+部位+强化/淬炼等级去重）；不关注顺序
+func equipEqual(a, b []t.EquipItem) bool {
+	if len(a) != len(b) {
+		// 可能位置名称不同但数量相同，这里仍需集合比较
+	}
+	set := func(list []t.EquipItem) map[string]int {
+		m := map[string]int{}
+		for _, e := range list {
+			key := fmt.Sprintf("%s|%s|%d|%d", e.Slot, e.Name, e.Enhance, e.Refine)
+			m[key]++
+		}
+		return m
+	}
+	sa, sb := set(a), set(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+	for k, va := range sa {
+		if vb, ok := sb[k]; !ok || vb != va {
+			return false
+		}
+	}
+	return true
+}
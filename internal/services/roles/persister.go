@@ -0,0 +1,251 @@
+package roles
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"wgserver/internal/config"
+	"wgserver/internal/db"
+	"wgserver/internal/logger"
+	t "wgserver/internal/types"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 角色写回（write-behind）：UpsertRole 只把最新属性放入内存队列，
+// 由后台协程按批次合并写入 MySQL，避免慢库阻塞全局写锁。
+
+const (
+	persistBaseBackoff = 500 * time.Millisecond
+	persistMaxBackoff  = 30 * time.Second
+)
+
+// PersistStats 写回队列的运行状态
+type PersistStats struct {
+	QueueDepth  int       `json:"queue_depth"`
+	Flushed     uint64    `json:"flushed"`
+	Retries     uint64    `json:"retries"`
+	Failures    uint64    `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+	LastFlushAt time.Time `json:"last_flush_at,omitempty"`
+}
+
+type persister struct {
+	mu      sync.Mutex
+	pending map[string]t.RoleAttributes // zone|role -> 最新属性（同一角色多次上报只保留最后一次）
+	order   []string                    // 入队顺序（FIFO）
+
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	write      func([]t.RoleAttributes) error
+
+	kick  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	stats PersistStats
+}
+
+func newPersister(batchSize int, interval time.Duration, maxRetries int, write func([]t.RoleAttributes) error) *persister {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &persister{
+		pending:    map[string]t.RoleAttributes{},
+		batchSize:  batchSize,
+		interval:   interval,
+		maxRetries: maxRetries,
+		write:      write,
+		kick:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func persistKey(zone, role string) string { return zone + "|" + role }
+
+func (p *persister) enqueue(r t.RoleAttributes) {
+	k := persistKey(r.Zone, r.RoleName)
+	p.mu.Lock()
+	if _, ok := p.pending[k]; !ok {
+		p.order = append(p.order, k)
+	}
+	p.pending[k] = r
+	full := len(p.pending) >= p.batchSize
+	p.mu.Unlock()
+	if full {
+		select {
+		case p.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *persister) run() {
+	defer close(p.done)
+	tk := time.NewTicker(p.interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			p.flushRound()
+		case <-p.kick:
+			p.flushRound()
+		case <-p.stop:
+			p.flushRound()
+			return
+		}
+	}
+}
+
+// flushRound 写出本轮开始时已在队列中的全部角色；失败的批次重新入队等待下一轮，
+// 因此数据库不可用时不会在此处死循环。
+func (p *persister) flushRound() {
+	p.mu.Lock()
+	n := len(p.order)
+	p.mu.Unlock()
+	for n > 0 {
+		batch := p.take(min(n, p.batchSize))
+		if len(batch) == 0 {
+			return
+		}
+		n -= len(batch)
+		if err := p.writeWithRetry(batch); err != nil {
+			p.requeue(batch, err)
+		}
+	}
+}
+
+func (p *persister) take(n int) []t.RoleAttributes {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n > len(p.order) {
+		n = len(p.order)
+	}
+	out := make([]t.RoleAttributes, 0, n)
+	for _, k := range p.order[:n] {
+		out = append(out, p.pending[k])
+		delete(p.pending, k)
+	}
+	p.order = p.order[n:]
+	return out
+}
+
+func (p *persister) writeWithRetry(batch []t.RoleAttributes) error {
+	backoff := persistBaseBackoff
+	var err error
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		if attempt > 0 {
+			p.mu.Lock()
+			p.stats.Retries++
+			p.mu.Unlock()
+			time.Sleep(backoff)
+			backoff = min(backoff*2, persistMaxBackoff)
+		}
+		if err = p.write(batch); err == nil {
+			p.mu.Lock()
+			p.stats.Flushed += uint64(len(batch))
+			p.stats.LastFlushAt = time.Now()
+			p.mu.Unlock()
+			return nil
+		}
+	}
+	return err
+}
+
+// requeue 将写失败的角色放回队列；若期间该角色已有更新的上报，则以新数据为准
+func (p *persister) requeue(batch []t.RoleAttributes, err error) {
+	p.mu.Lock()
+	p.stats.Failures++
+	p.stats.LastError = err.Error()
+	p.stats.LastErrorAt = time.Now()
+	for _, r := range batch {
+		k := persistKey(r.Zone, r.RoleName)
+		if _, ok := p.pending[k]; ok {
+			continue
+		}
+		p.pending[k] = r
+		p.order = append(p.order, k)
+	}
+	depth := len(p.order)
+	p.mu.Unlock()
+	logger.RoleInfo().Printf("persist failed batch=%d queue=%d err=%v", len(batch), depth, err)
+}
+
+func (p *persister) snapshotStats() PersistStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.stats
+	st.QueueDepth = len(p.order)
+	return st
+}
+
+// StartPersister 启动角色写回协程；未启动时 UpsertRole 不落库（如离线工具场景）
+func (m *Manager) StartPersister(cfg *config.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.persist != nil {
+		return
+	}
+	m.persist = newPersister(cfg.PersistBatchSize, cfg.PersistFlushInterval, cfg.PersistMaxRetries, saveRoles)
+	go m.persist.run()
+}
+
+// StopPersister 停止写回协程并尽力写出剩余队列；ctx 到期时放弃等待
+func (m *Manager) StopPersister(ctx context.Context) error {
+	m.mu.Lock()
+	p := m.persist
+	m.persist = nil
+	m.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+	close(p.stop)
+	select {
+	case <-p.done:
+		if st := p.snapshotStats(); st.QueueDepth > 0 {
+			logger.RoleInfo().Printf("persist stopped with %d roles unsaved", st.QueueDepth)
+		}
+		return nil
+	case <-ctx.Done():
+		logger.RoleInfo().Printf("persist stop timeout; queue=%d", p.snapshotStats().QueueDepth)
+		return ctx.Err()
+	}
+}
+
+// PersistStats 返回写回队列状态；未启动时返回零值
+func (m *Manager) PersistStats() PersistStats {
+	m.mu.RLock()
+	p := m.persist
+	m.mu.RUnlock()
+	if p == nil {
+		return PersistStats{}
+	}
+	return p.snapshotStats()
+}
+
+const roleColumns = 14
+
+// saveRoles 以单条多值 INSERT ... ON DUPLICATE KEY UPDATE 批量写入
+func saveRoles(batch []t.RoleAttributes) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	row := "(" + db.Placeholders(roleColumns) + ")"
+	rows := make([]string, len(batch))
+	args := make([]any, 0, len(batch)*roleColumns)
+	for i, r := range batch {
+		rows[i] = row
+		args = append(args, r.RoleName, r.Zone, r.MergeState, r.Class, r.School, r.Skill, r.Level, r.Lucky, r.Magic, r.MapName, r.ClientID, r.CreatedAt, r.X, r.Y)
+	}
+	q := `INSERT INTO roles (role_name, zone, merge_state, class, school, skill, level, lucky, magic, current_map, client_id, created_at, x, y)
+		VALUES ` + strings.Join(rows, ",") + `
+		ON DUPLICATE KEY UPDATE merge_state=VALUES(merge_state), class=VALUES(class), school=VALUES(school), skill=VALUES(skill), level=VALUES(level), lucky=VALUES(lucky), magic=VALUES(magic), current_map=VALUES(current_map), client_id=VALUES(client_id), x=VALUES(x), y=VALUES(y)`
+	return db.Tx(func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(q, args...)
+		return err
+	})
+}
@@ -0,0 +1,221 @@
+package roles
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"wgserver/internal/logger"
+	t "wgserver/internal/types"
+)
+
+// 角色属性校验：越界、职业/流派不匹配、两次上报间变化过快的数据进入隔离区，
+// 不参与规划，由管理员通过 /admin/roles/quarantine 查看并放行或丢弃。
+
+type Range struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// Rate 两次上报间允许的变化量：PerReport + PerHour × 间隔小时数（按实际间隔折算，向上取整）
+type Rate struct {
+	PerReport int `json:"per_report"`
+	PerHour   int `json:"per_hour"`
+}
+
+// limit 间隔 hours 小时内允许的变化量
+func (r Rate) limit(hours float64) int {
+	return r.PerReport + int(math.Ceil(float64(r.PerHour)*hours))
+}
+
+type ValidationRules struct {
+	Ranges           map[string]Range    `json:"ranges"`  // 字段（等级/技能/幸运/道术/血量）-> 取值范围
+	Schools          map[string][]string `json:"schools"` // 职业 -> 允许的流派；空流派始终允许
+	MaxRise          map[string]Rate     `json:"max_rise"`
+	MaxDrop          map[string]Rate     `json:"max_drop"`
+	AllowClassChange bool                `json:"allow_class_change"`
+}
+
+func DefaultValidationRules() ValidationRules {
+	return ValidationRules{
+		Ranges: map[string]Range{
+			"等级": {Min: 0, Max: 150},
+			"技能": {Min: 0, Max: 300},
+			"幸运": {Min: 0, Max: 9},
+			"道术": {Min: 0, Max: 100000},
+			"血量": {Min: 0, Max: 10000000},
+		},
+		Schools: defaultSchools(),
+		MaxRise: map[string]Rate{
+			"等级": {PerReport: 3, PerHour: 2},
+			"技能": {PerReport: 20, PerHour: 10},
+		},
+		MaxDrop: map[string]Rate{
+			"等级": {},
+			"技能": {},
+		},
+	}
+}
+
+// defaultSchools 复制共用的职业流派表（规则文件会合并进该 map，不能直接引用共享数据）
+func defaultSchools() map[string][]string {
+	out := make(map[string][]string, len(t.ClassSchools))
+	for class, schools := range t.ClassSchools {
+		out[class] = append([]string(nil), schools...)
+	}
+	return out
+}
+
+// LoadValidationRules 从 JSON 文件加载校验规则；文件中未出现的部分沿用默认值
+func (m *Manager) LoadValidationRules(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules := DefaultValidationRules()
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for f, rg := range rules.Ranges {
+		if rg.Min > rg.Max {
+			return fmt.Errorf("range %s: min %d > max %d", f, rg.Min, rg.Max)
+		}
+	}
+	m.mu.Lock()
+	m.validation = rules
+	m.mu.Unlock()
+	logger.RoleInfo().Printf("validation rules loaded from %s", path)
+	return nil
+}
+
+func numericFields(r *t.RoleAttributes) map[string]int {
+	return map[string]int{"等级": r.Level, "技能": r.Skill, "幸运": r.Lucky, "道术": r.Magic, "血量": r.HP}
+}
+
+// validate 返回可疑原因列表；prev 为该角色上次被采信的数据（可能已离线）
+func (v *ValidationRules) validate(prev *RoleInfo, r *t.RoleAttributes, now time.Time) []string {
+	var reasons []string
+	cur := numericFields(r)
+	fields := make([]string, 0, len(cur))
+	for f := range cur {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		if rg, ok := v.Ranges[f]; ok && (cur[f] < rg.Min || cur[f] > rg.Max) {
+			reasons = append(reasons, fmt.Sprintf("%s=%d 超出范围[%d,%d]", f, cur[f], rg.Min, rg.Max))
+		}
+	}
+	if schools, ok := v.Schools[r.Class]; !ok {
+		reasons = append(reasons, fmt.Sprintf("未知职业 %q", r.Class))
+	} else if r.School != "" && !containsStr(schools, r.School) {
+		reasons = append(reasons, fmt.Sprintf("职业 %s 不允许流派 %s", r.Class, r.School))
+	}
+	if prev == nil {
+		return reasons
+	}
+	if !v.AllowClassChange && prev.Class != r.Class {
+		reasons = append(reasons, fmt.Sprintf("职业变更 %s->%s", prev.Class, r.Class))
+	}
+	hours := now.Sub(prev.UpdatedAt).Hours()
+	if prev.UpdatedAt.IsZero() || hours < 0 {
+		hours = 0
+	}
+	old := numericFields(&prev.RoleAttributes)
+	for _, f := range fields {
+		d := cur[f] - old[f]
+		if rate, ok := v.MaxRise[f]; ok && d > 0 {
+			if limit := rate.limit(hours); d > limit {
+				reasons = append(reasons, fmt.Sprintf("%s 上升过快 %d->%d（允许 %d）", f, old[f], cur[f], limit))
+			}
+		}
+		if rate, ok := v.MaxDrop[f]; ok && d < 0 {
+			if limit := rate.limit(hours); -d > limit {
+				reasons = append(reasons, fmt.Sprintf("%s 下降 %d->%d（允许 %d）", f, old[f], cur[f], limit))
+			}
+		}
+	}
+	return reasons
+}
+
+// QuarantineEntry 被隔离的一次上报（同一角色只保留最新一次）
+type QuarantineEntry struct {
+	Zone     string           `json:"zone"`
+	Role     string           `json:"role"`
+	ClientID string           `json:"client_id"`
+	Reasons  []string         `json:"reasons"`
+	Attrs    t.RoleAttributes `json:"attrs"`
+	Count    int              `json:"count"`
+	FirstAt  time.Time        `json:"first_at"`
+	LastAt   time.Time        `json:"last_at"`
+}
+
+func (m *Manager) quarantineLocked(r t.RoleAttributes, reasons []string, now time.Time) {
+	key := persistKey(r.Zone, r.RoleName)
+	e := m.quarantine[key]
+	if e == nil {
+		e = &QuarantineEntry{Zone: r.Zone, Role: r.RoleName, FirstAt: now}
+		m.quarantine[key] = e
+	}
+	changed := fmt.Sprint(e.Reasons) != fmt.Sprint(reasons)
+	e.ClientID = r.ClientID
+	e.Reasons = reasons
+	e.Attrs = r
+	e.Count++
+	e.LastAt = now
+	if changed {
+		logger.RoleInfo().Printf("quarantine zone=%s role=%s client_id=%s reasons=%v", r.Zone, r.RoleName, r.ClientID, reasons)
+	}
+}
+
+// ListQuarantine 返回隔离区条目（按区服、角色排序）
+func (m *Manager) ListQuarantine() []QuarantineEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]QuarantineEntry, 0, len(m.quarantine))
+	for _, e := range m.quarantine {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Zone != out[j].Zone {
+			return out[i].Zone < out[j].Zone
+		}
+		return out[i].Role < out[j].Role
+	})
+	return out
+}
+
+// ReleaseQuarantine 处理隔离条目：accept 为 true 时采信该次上报（作为新的比较基线），否则丢弃
+func (m *Manager) ReleaseQuarantine(zone, role string, accept bool) (*RoleInfo, error) {
+	m.mu.Lock()
+	zone = m.resolveZoneLocked(zone)
+	key := persistKey(zone, role)
+	e := m.quarantine[key]
+	if e == nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("no quarantined report for %s/%s", zone, role)
+	}
+	delete(m.quarantine, key)
+	m.mu.Unlock()
+	logger.RoleInfo().Printf("quarantine release zone=%s role=%s accept=%v", zone, role, accept)
+	if !accept {
+		return nil, nil
+	}
+	ri, _ := m.upsert(e.Attrs, false)
+	if ri == nil {
+		return nil, fmt.Errorf("report for %s/%s rejected by ownership policy", zone, role)
+	}
+	return ri, nil
+}
+
+func containsStr(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package roles
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"wgserver/internal/db"
+	"wgserver/internal/logger"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 区服注册表：合区后旧 充值区服 作为别名指向合并后的区服，
+// 并可由管理员为合并后的区服指定统一的 合区 状态。
+
+// MergeResult 合区操作结果
+type MergeResult struct {
+	Zone       string   `json:"zone"`
+	MergeState string   `json:"merge_state"`
+	Sources    []string `json:"sources"`
+	Moved      int      `json:"moved"`
+	Collisions []string `json:"collisions,omitempty"` // 目标区已存在同名角色，保留目标区数据（原区角色按移除事件发布）
+	Errors     []string `json:"errors,omitempty"`     // 部分失败：内存已合并但某项落库失败，需人工核对
+}
+
+// ZoneAlias 别名条目
+type ZoneAlias struct {
+	Alias string `json:"alias" db:"alias"`
+	Zone  string `json:"zone" db:"zone"`
+}
+
+// ResolveZone 将上报的区服名解析为规划使用的区服名
+func (m *Manager) ResolveZone(zone string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.resolveZoneLocked(zone)
+}
+
+func (m *Manager) resolveZoneLocked(zone string) string {
+	// 别名在合并时已压平为一跳，这里仍做有限次追踪以防配置表中存在链
+	for i := 0; i < 8; i++ {
+		next, ok := m.aliases[zone]
+		if !ok || next == zone {
+			break
+		}
+		zone = next
+	}
+	return zone
+}
+
+// MergeStateOverride 返回管理员为区服指定的合区状态（未指定返回空）
+func (m *Manager) MergeStateOverride(zone string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.mergeStates[zone]
+}
+
+// ListAliases 返回全部别名（按别名排序）
+func (m *Manager) ListAliases() []ZoneAlias {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]ZoneAlias, 0, len(m.aliases))
+	for a, z := range m.aliases {
+		out = append(out, ZoneAlias{Alias: a, Zone: z})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Alias < out[j].Alias })
+	return out
+}
+
+// MergeZones 将 sources 区服的在线角色并入 target，旧区服名登记为 target 的别名，
+// 并将 target 下全部角色的合区状态统一为 mergeState。任务队列、交换事务与分配计划
+// 由 server 在调用后分别迁移。
+func (m *Manager) MergeZones(target string, sources []string, mergeState string) (MergeResult, error) {
+	if target == "" || len(sources) == 0 {
+		return MergeResult{}, errors.New("target and sources are required")
+	}
+	if mergeState == "" {
+		return MergeResult{}, errors.New("merge_state is required")
+	}
+	m.mu.Lock()
+	target = m.resolveZoneLocked(target)
+	res := MergeResult{Zone: target, MergeState: mergeState}
+	seen := map[string]bool{}
+	for _, s := range sources {
+		s = m.resolveZoneLocked(s)
+		if s == "" || s == target || seen[s] {
+			continue
+		}
+		seen[s] = true
+		res.Sources = append(res.Sources, s)
+	}
+	if len(res.Sources) == 0 {
+		m.mu.Unlock()
+		return MergeResult{}, errors.New("no source zone differs from target")
+	}
+
+	tz := m.editZoneLocked(target)
+	for _, s := range res.Sources {
+		if sz := m.zones[s]; sz != nil {
+			for name, ri := range sz.Roles {
+				// 无论是否并入都从原区服移除；同名时保留目标区数据
+				m.publish(removedEvent(ri, "merge"))
+				if _, dup := tz.Roles[name]; dup {
+					res.Collisions = append(res.Collisions, s+"|"+name)
+					continue
+				}
+				tz.Roles[name] = ri
+				tz.ClientByRole[name] = sz.ClientByRole[name]
+				res.Moved++
+			}
+			delete(m.zones, s)
+		}
+		// 离线角色、冲突记录、校验基线与隔离条目随区服迁移；与目标区同名时保留目标区
+		if off := m.offline[s]; off != nil {
+			if m.offline[target] == nil {
+				m.offline[target] = map[string]*RoleInfo{}
+			}
+			for name, ri := range off {
+				if _, online := tz.Roles[name]; online || m.offline[target][name] != nil {
+					continue
+				}
+				m.offline[target][name] = ri
+			}
+			delete(m.offline, s)
+		}
+		prefix := s + "|"
+		for k, cs := range m.conflicts {
+			if strings.HasPrefix(k, prefix) {
+				if nk := target + strings.TrimPrefix(k, s); m.conflicts[nk] == nil {
+					m.conflicts[nk] = cs
+				}
+				delete(m.conflicts, k)
+			}
+		}
+		for k, b := range m.baseline {
+			if strings.HasPrefix(k, prefix) {
+				if nk := target + strings.TrimPrefix(k, s); m.baseline[nk] == nil {
+					m.baseline[nk] = b
+				}
+				delete(m.baseline, k)
+			}
+		}
+		for k, e := range m.quarantine {
+			if strings.HasPrefix(k, prefix) {
+				if nk := target + strings.TrimPrefix(k, s); m.quarantine[nk] == nil {
+					e.Zone, e.Attrs.Zone = target, target
+					m.quarantine[nk] = e
+				}
+				delete(m.quarantine, k)
+			}
+		}
+		// 压平：原先指向 s 的别名改为直接指向 target
+		for a, z := range m.aliases {
+			if z == s {
+				m.aliases[a] = target
+			}
+		}
+		m.aliases[s] = target
+		delete(m.mergeStates, s)
+	}
+	delete(m.aliases, target)
+	m.mergeStates[target] = mergeState
+
+	// 统一区服名与合区状态；RoleInfo 视为只读，替换为新对象
+	for name, ri := range tz.Roles {
+		nr := *ri
+		nr.Zone = target
+		nr.MergeState = mergeState
+		tz.Roles[name] = &nr
+		if ri.Zone != target {
+			m.publish(Event{Kind: EventRoleAdded, Zone: target, Role: name, ClientID: nr.ClientID, New: &nr})
+		}
+		if m.persist != nil {
+			m.persist.enqueue(nr.RoleAttributes)
+		}
+	}
+	for name, ri := range m.offline[target] {
+		nr := *ri
+		nr.Zone = target
+		nr.MergeState = mergeState
+		m.offline[target][name] = &nr
+	}
+	tz.LastUpdate = time.Now()
+	m.commitZoneLocked(target, tz)
+	m.mu.Unlock()
+
+	if err := saveZoneMerge(target, res.Sources, mergeState); err != nil {
+		logger.RoleInfo().Printf("zone merge persist failed zone=%s err=%v", target, err)
+		res.Errors = append(res.Errors, "zone_aliases: "+err.Error())
+	}
+	logger.RoleInfo().Printf("zone merge zone=%s sources=%v merge=%s moved=%d collisions=%d",
+		target, res.Sources, mergeState, res.Moved, len(res.Collisions))
+	return res, nil
+}
+
+// LoadZoneAliases 启动时从数据库加载别名与合区状态
+func (m *Manager) LoadZoneAliases() error {
+	if db.DB() == nil {
+		return nil
+	}
+	var rows []struct {
+		Alias      string `db:"alias"`
+		Zone       string `db:"zone"`
+		MergeState string `db:"merge_state"`
+	}
+	if err := db.DB().Select(&rows, `SELECT alias, zone, merge_state FROM zone_aliases`); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range rows {
+		m.aliases[r.Alias] = r.Zone
+		if r.MergeState != "" {
+			m.mergeStates[r.Zone] = r.MergeState
+		}
+	}
+	return nil
+}
+
+func saveZoneMerge(target string, sources []string, mergeState string) error {
+	if db.DB() == nil {
+		return nil
+	}
+	return db.Tx(func(tx *sqlx.Tx) error {
+		for _, s := range sources {
+			if _, err := tx.Exec(`INSERT INTO zone_aliases (alias, zone, merge_state) VALUES (?,?,?)
+				ON DUPLICATE KEY UPDATE zone=VALUES(zone), merge_state=VALUES(merge_state)`, s, target, mergeState); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE zone_aliases SET zone=?, merge_state=? WHERE zone=?`, target, mergeState, s); err != nil {
+				return err
+			}
+		}
+		// 历史行迁移到新区服；与目标区同名的行保留原区服（UPDATE IGNORE 跳过唯一键冲突）
+		q, args := db.InClause("zone", sources)
+		_, err := tx.Exec(`UPDATE IGNORE roles SET zone=?, merge_state=? WHERE `+q, append([]any{target, mergeState}, args...)...)
+		return err
+	})
+}
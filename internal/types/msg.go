@@ -1,10 +1,12 @@
-package types
-
-type MsgType string
-
-const (
-	MsgTypeHeartbeatResponse MsgType = "heartbeat_response"
-	MsgTypeHeartbeat         MsgType = "heartbeat"
-	MsgTypeConnectionAck     MsgType = "connection_ack"
-	MsgTypeDailyTask         MsgType = "日常任务"
-)
+package types
+
+type MsgType string
+
+const (
+	MsgTypeHeartbeatResponse MsgType = "heartbeat_response"
+	MsgTypeHeartbeat         MsgType = "heartbeat"
+	MsgTypeConnectionAck     MsgType = "connection_ack"
+	MsgTypeDailyTask         MsgType = "日常任务"
+	MsgTypeRoleConflict      MsgType = "角色冲突"
+	MsgTypeQueryAssignment   MsgType = "查询分配"
+)